@@ -56,11 +56,19 @@ func main() {
 	if err != nil {
 		log.Fatal("Failed to create API Gateway", zap.Error(err))
 	}
+	defer gw.Close()
 
-	// Create HTTP server
+	// Create HTTP server. Headers up to the largest configured limit are read
+	// so that the gateway can reject larger ones per route.
+	seconds := func(n int) time.Duration { return time.Duration(n) * time.Second }
 	server := &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
-		Handler: gw,
+		Addr:              fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
+		Handler:           gw,
+		ReadHeaderTimeout: seconds(cfg.Server.ReadHeaderTimeoutSeconds),
+		ReadTimeout:       seconds(cfg.Server.ReadTimeoutSeconds),
+		WriteTimeout:      seconds(cfg.Server.WriteTimeoutSeconds),
+		IdleTimeout:       seconds(cfg.Server.IdleTimeoutSeconds),
+		MaxHeaderBytes:    cfg.MaxRequestLimits().MaxHeaderBytes,
 	}
 
 	// Start the server in a goroutine
@@ -71,6 +79,27 @@ func main() {
 		}
 	}()
 
+	// Serve the admin API on its own listener if configured, with the same
+	// timeouts and the global request limits
+	var adminServer *http.Server
+	if adminHandler := gw.AdminHandler(); adminHandler != nil {
+		adminServer = &http.Server{
+			Addr:              cfg.Admin.ListenAddress,
+			Handler:           adminHandler,
+			ReadHeaderTimeout: seconds(cfg.Server.ReadHeaderTimeoutSeconds),
+			ReadTimeout:       seconds(cfg.Server.ReadTimeoutSeconds),
+			WriteTimeout:      seconds(cfg.Server.WriteTimeoutSeconds),
+			IdleTimeout:       seconds(cfg.Server.IdleTimeoutSeconds),
+			MaxHeaderBytes:    cfg.Limits.MaxHeaderBytes,
+		}
+		go func() {
+			log.Info("Starting admin HTTP server", zap.String("address", adminServer.Addr))
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Admin HTTP server error", zap.Error(err))
+			}
+		}()
+	}
+
 	// Set up graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
@@ -87,6 +116,11 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Error("Server shutdown error", zap.Error(err))
 	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			log.Error("Admin server shutdown error", zap.Error(err))
+		}
+	}
 
 	log.Info("Server stopped, goodbye!")
 }
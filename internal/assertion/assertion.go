@@ -0,0 +1,290 @@
+// Package assertion mints short-lived JWTs in which the gateway asserts the
+// identity of the caller to upstream services, and publishes the public key
+// as a JWKS so upstreams can verify them.
+package assertion
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+// Supported signing algorithms
+const (
+	AlgEdDSA = "EdDSA" // Ed25519
+	AlgRS256 = "RS256" // RSASSA-PKCS1-v1_5 with SHA-256
+)
+
+// minRSABits is the smallest accepted RSA key size
+const minRSABits = 2048
+
+// Options configures a Signer
+type Options struct {
+	// Algorithm is AlgEdDSA or AlgRS256
+	Algorithm string
+	// PrivateKeyFile is a PEM-encoded PKCS#8 (or PKCS#1 for RSA) private key.
+	// If empty, a key is generated at startup and lost on restart.
+	PrivateKeyFile string
+	Issuer         string
+	Audience       string // Optional "aud" claim
+	TTL            time.Duration
+}
+
+// Role is a role as asserted to upstreams
+type Role struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Permissions are the caller's effective permissions
+type Permissions struct {
+	Publish   []string `json:"publish"`
+	Subscribe []string `json:"subscribe"`
+}
+
+// Principal is the authenticated caller
+type Principal struct {
+	UserID      string
+	Username    string
+	Roles       []Role // Primary role first
+	Permissions Permissions
+}
+
+// claims is the JWT payload
+type claims struct {
+	Issuer      string      `json:"iss"`
+	Subject     string      `json:"sub"`
+	Audience    string      `json:"aud,omitempty"`
+	IssuedAt    int64       `json:"iat"`
+	NotBefore   int64       `json:"nbf"`
+	ExpiresAt   int64       `json:"exp"`
+	ID          string      `json:"jti"`
+	Username    string      `json:"username,omitempty"`
+	Role        string      `json:"role,omitempty"` // Primary role name
+	Roles       []Role      `json:"roles"`
+	Permissions Permissions `json:"permissions"`
+}
+
+// JWK is a public JSON Web Key
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// Signer signs identity assertions
+type Signer struct {
+	algorithm string
+	key       crypto.Signer
+	keyID     string
+	header    string // Encoded JWT header
+	jwk       JWK
+	issuer    string
+	audience  string
+	ttl       time.Duration
+}
+
+// NewSigner loads or generates the signing key
+func NewSigner(opts Options) (*Signer, error) {
+	var (
+		key crypto.Signer
+		err error
+	)
+	if opts.PrivateKeyFile != "" {
+		key, err = loadKey(opts.PrivateKeyFile)
+	} else {
+		key, err = generateKey(opts.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Signer{
+		algorithm: opts.Algorithm,
+		key:       key,
+		issuer:    opts.Issuer,
+		audience:  opts.Audience,
+		ttl:       opts.TTL,
+	}
+
+	// The key must match the algorithm
+	switch k := key.Public().(type) {
+	case ed25519.PublicKey:
+		if opts.Algorithm != AlgEdDSA {
+			return nil, fmt.Errorf("an Ed25519 key can't be used with %s", opts.Algorithm)
+		}
+		s.jwk = JWK{KeyType: "OKP", Curve: "Ed25519", X: encode(k)}
+	case *rsa.PublicKey:
+		if opts.Algorithm != AlgRS256 {
+			return nil, fmt.Errorf("an RSA key can't be used with %s", opts.Algorithm)
+		}
+		if k.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key must have at least %d bits, got %d", minRSABits, k.N.BitLen())
+		}
+		s.jwk = JWK{KeyType: "RSA", N: encode(k.N.Bytes()), E: encode(big.NewInt(int64(k.E)).Bytes())}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", k)
+	}
+
+	s.keyID = thumbprint(s.jwk)
+	s.jwk.Use = "sig"
+	s.jwk.Algorithm = opts.Algorithm
+	s.jwk.KeyID = s.keyID
+
+	header, err := json.Marshal(map[string]string{
+		"alg": opts.Algorithm,
+		"typ": "JWT",
+		"kid": s.keyID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode JWT header: %w", err)
+	}
+	s.header = encode(header)
+
+	return s, nil
+}
+
+// KeyID returns the ID of the signing key, its RFC 7638 thumbprint
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// Sign mints an assertion for the principal
+func (s *Signer) Sign(principal Principal) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", fmt.Errorf("failed to generate JWT ID: %w", err)
+	}
+
+	now := time.Now()
+	c := claims{
+		Issuer:      s.issuer,
+		Subject:     principal.UserID,
+		Audience:    s.audience,
+		IssuedAt:    now.Unix(),
+		NotBefore:   now.Unix(),
+		ExpiresAt:   now.Add(s.ttl).Unix(),
+		ID:          encode(jti),
+		Username:    principal.Username,
+		Roles:       principal.Roles,
+		Permissions: principal.Permissions,
+	}
+	if len(principal.Roles) > 0 {
+		c.Role = principal.Roles[0].Name
+	}
+	if c.Roles == nil {
+		c.Roles = []Role{}
+	}
+
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode JWT claims: %w", err)
+	}
+
+	signingInput := s.header + "." + encode(payload)
+
+	var signature []byte
+	switch s.algorithm {
+	case AlgEdDSA:
+		signature, err = s.key.Sign(rand.Reader, []byte(signingInput), crypto.Hash(0))
+	case AlgRS256:
+		digest := sha256.Sum256([]byte(signingInput))
+		signature, err = s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to sign assertion: %w", err)
+	}
+
+	return signingInput + "." + encode(signature), nil
+}
+
+// JWKS returns the JSON Web Key Set with the public key
+func (s *Signer) JWKS() map[string][]JWK {
+	return map[string][]JWK{"keys": {s.jwk}}
+}
+
+// loadKey reads a PEM-encoded private key
+func loadKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read assertion signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("assertion signing key %s is not PEM-encoded", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in assertion signing key", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse assertion signing key: %w", err)
+	}
+
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported assertion signing key type %T", parsed)
+	}
+	return key, nil
+}
+
+// generateKey creates an ephemeral key for the algorithm
+func generateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case AlgEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate Ed25519 key: %w", err)
+		}
+		return key, nil
+	case AlgRS256:
+		key, err := rsa.GenerateKey(rand.Reader, minRSABits)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA key: %w", err)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported assertion algorithm %q", algorithm)
+	}
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint from the required members
+// in lexicographic order
+func thumbprint(jwk JWK) string {
+	var members string
+	switch jwk.KeyType {
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, jwk.Curve, jwk.KeyType, jwk.X)
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, jwk.E, jwk.KeyType, jwk.N)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return encode(sum[:])
+}
+
+// encode is unpadded base64url
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
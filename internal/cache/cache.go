@@ -3,52 +3,111 @@
 package cache
 
 import (
+	"errors"
 	"sync"
 	"time"
 
+	"api-gateway/internal/jwtutil"
 	"api-gateway/internal/pocketbase"
 	"go.uber.org/zap"
 )
 
 // Cache is an in-memory cache for user and role data
 type Cache struct {
-	userCache       map[string]*pocketbase.User // Map hashed token -> User
-	roleCache       map[string]*pocketbase.Role // Map ID -> Role
+	userCache       *expiringLRU[*pocketbase.User]   // Map hashed token -> User
+	rejectedTokens  *expiringLRU[struct{}]           // Set of hashed tokens recently rejected
+	roleCache       map[string]*pocketbase.Role      // Map ID -> Role
+	effectiveCache  map[string]*EffectivePermissions // Map role ID -> resolved permissions
 	mutex           sync.RWMutex
 	ttl             time.Duration
+	maxTokenTTL     time.Duration
+	negativeTTL     time.Duration
 	lastRefreshTime time.Time
 	logger          *zap.Logger
 	tokenHasher     *TokenHasher
 }
 
-// New creates a new cache with the specified TTL
-func New(ttl time.Duration, logger *zap.Logger) *Cache {
+// Options contains the token cache limits
+type Options struct {
+	// MaxTokenTTL caps how long a validated token is cached,
+	// even if the token itself expires later
+	MaxTokenTTL time.Duration
+	
+	// MaxTokens bounds the number of cached tokens; the least
+	// recently used entries are evicted first
+	MaxTokens int
+	
+	// NegativeTTL is how long a rejected token is remembered.
+	// Zero disables negative caching.
+	NegativeTTL time.Duration
+	
+	// MaxRejectedTokens bounds the number of remembered rejected tokens
+	MaxRejectedTokens int
+}
+
+// New creates a new cache with the specified TTL and token cache limits
+func New(ttl time.Duration, opts Options, logger *zap.Logger) *Cache {
 	return &Cache{
-		userCache:   make(map[string]*pocketbase.User),
-		roleCache:   make(map[string]*pocketbase.Role),
-		ttl:         ttl,
-		logger:      logger,
-		tokenHasher: NewTokenHasher(),
+		userCache:      newExpiringLRU[*pocketbase.User](opts.MaxTokens),
+		rejectedTokens: newExpiringLRU[struct{}](opts.MaxRejectedTokens),
+		roleCache:      make(map[string]*pocketbase.Role),
+		effectiveCache: make(map[string]*EffectivePermissions),
+		ttl:            ttl,
+		maxTokenTTL:    opts.MaxTokenTTL,
+		negativeTTL:    opts.NegativeTTL,
+		logger:         logger,
+		tokenHasher:    NewTokenHasher(),
 	}
 }
 
 // GetUserByToken retrieves a user from the cache by token
 // The token is hashed before lookup to avoid storing raw tokens
-// Returns nil if the user is not in the cache
+// Returns nil if the user is not in the cache or the entry has expired
 func (c *Cache) GetUserByToken(token string) *pocketbase.User {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-	
 	// Hash the token to get the cache key
 	hashedToken := c.tokenHasher.HashToken(token)
 	
-	user, found := c.userCache[hashedToken]
+	// A lookup updates recency and may drop an expired entry, so it needs the write lock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	
+	user, found := c.userCache.Get(hashedToken, time.Now())
 	if !found {
 		return nil
 	}
 	return user
 }
 
+// IsTokenRejected reports whether a token was recently rejected by the identity provider
+func (c *Cache) IsTokenRejected(token string) bool {
+	if c.negativeTTL <= 0 {
+		return false
+	}
+	
+	hashedToken := c.tokenHasher.HashToken(token)
+	
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	
+	_, found := c.rejectedTokens.Get(hashedToken, time.Now())
+	return found
+}
+
+// AddRejectedToken remembers a rejected token for the negative TTL so that
+// repeated requests with the same bad token don't reach the identity provider
+func (c *Cache) AddRejectedToken(token string) {
+	if c.negativeTTL <= 0 {
+		return
+	}
+	
+	hashedToken := c.tokenHasher.HashToken(token)
+	
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	
+	c.rejectedTokens.Add(hashedToken, struct{}{}, time.Now().Add(c.negativeTTL))
+}
+
 // GetRoleByID retrieves a role from the cache by its ID
 // Returns nil if the role is not in the cache
 func (c *Cache) GetRoleByID(id string) *pocketbase.Role {
@@ -63,18 +122,38 @@ func (c *Cache) GetRoleByID(id string) *pocketbase.Role {
 }
 
 // AddUser adds or updates a user in the cache
-// The token is hashed before being used as a key for security
+// The token is hashed before being used as a key for security.
+// The entry expires with the token's "exp" claim or the expiry the store
+// reported for the token, capped by the maximum token TTL.
 func (c *Cache) AddUser(token string, user *pocketbase.User) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+	now := time.Now()
+	expiresAt := now.Add(c.maxTokenTTL)
+	if claims, err := jwtutil.ParseUnverified(token); err == nil {
+		if exp := claims.Expiry(); !exp.IsZero() && exp.Before(expiresAt) {
+			expiresAt = exp
+		}
+	}
+	if exp := user.TokenExpiresAt; !exp.IsZero() && exp.Before(expiresAt) {
+		expiresAt = exp
+	}
+	
+	// Don't bother caching tokens that are already expired
+	if !now.Before(expiresAt) {
+		return
+	}
 	
 	// Hash the token to get the cache key
 	hashedToken := c.tokenHasher.HashToken(token)
 	
-	c.userCache[hashedToken] = user
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	
+	evicted := c.userCache.Add(hashedToken, user, expiresAt)
 	c.logger.Debug("Added user to cache", 
 		zap.String("username", user.Username), 
-		zap.String("hashed_token", hashedToken[:8]+"...")) // Log prefix of hash for debugging
+		zap.String("hashed_token", hashedToken[:8]+"..."), // Log prefix of hash for debugging
+		zap.Time("expires_at", expiresAt),
+		zap.Int("evicted", evicted))
 }
 
 // AddRole adds or updates a role in the cache
@@ -83,68 +162,275 @@ func (c *Cache) AddRole(id string, role *pocketbase.Role) {
 	defer c.mutex.Unlock()
 	
 	c.roleCache[id] = role
+	
+	// Descendants may inherit from this role, so drop all resolved permissions
+	c.effectiveCache = make(map[string]*EffectivePermissions)
+	
 	c.logger.Debug("Added role to cache", zap.String("role", role.Name))
 }
 
+// RemoveRole removes a role from the cache
+func (c *Cache) RemoveRole(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	
+	delete(c.roleCache, id)
+	
+	// Descendants may inherit from this role, so drop all resolved permissions
+	c.effectiveCache = make(map[string]*EffectivePermissions)
+	
+	c.logger.Debug("Removed role from cache", zap.String("role_id", id))
+}
+
+// UpdateUser replaces the user record of every cached token belonging to the user
+func (c *Cache) UpdateUser(user *pocketbase.User) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	
+	updated := 0
+	c.userCache.Update(func(_ string, cached *pocketbase.User) (*pocketbase.User, bool) {
+		if cached.ID == user.ID {
+			updated++
+			return user, true
+		}
+		return cached, true
+	})
+	
+	c.logger.Debug("Updated cached user", 
+		zap.String("user_id", user.ID),
+		zap.Int("tokens", updated))
+}
+
+// RemoveUser drops every cached token belonging to the user and returns how many were removed
+func (c *Cache) RemoveUser(userID string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	
+	removed := c.userCache.Update(func(_ string, cached *pocketbase.User) (*pocketbase.User, bool) {
+		return cached, cached.ID != userID
+	})
+	
+	c.logger.Debug("Removed user tokens from cache", 
+		zap.String("user_id", userID),
+		zap.Int("tokens", removed))
+	
+	return removed
+}
+
+// RemoveRoleUsers drops every cached token belonging to a user that holds the
+// role, directly or through a role inheriting from it, and returns how many were removed
+func (c *Cache) RemoveRoleUsers(roleID string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	
+	// Memoize which roles inherit from the given role
+	inherits := map[string]bool{roleID: true}
+	var inheritsFrom func(id string) bool
+	inheritsFrom = func(id string) bool {
+		visited := make(map[string]bool)
+		for current := id; current != ""; {
+			if result, known := inherits[current]; known {
+				inherits[id] = result
+				return result
+			}
+			// Stop on cycles and missing roles
+			role := c.roleCache[current]
+			if visited[current] || role == nil {
+				break
+			}
+			visited[current] = true
+			current = role.ParentID
+		}
+		inherits[id] = false
+		return false
+	}
+	
+	removed := c.userCache.Update(func(_ string, cached *pocketbase.User) (*pocketbase.User, bool) {
+		for _, id := range cached.AllRoleIDs() {
+			if inheritsFrom(id) {
+				return cached, false
+			}
+		}
+		return cached, true
+	})
+	
+	c.logger.Debug("Removed role tokens from cache", 
+		zap.String("role_id", roleID),
+		zap.Int("tokens", removed))
+	
+	return removed
+}
+
 // ClearCache clears all cached users and roles
 func (c *Cache) ClearCache() {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 	
-	c.userCache = make(map[string]*pocketbase.User)
+	c.userCache.Purge()
+	c.rejectedTokens.Purge()
 	c.roleCache = make(map[string]*pocketbase.Role)
-	c.lastRefreshTime = time.Now()
+	c.effectiveCache = make(map[string]*EffectivePermissions)
 	
 	c.logger.Debug("Cache cleared")
 }
 
-// RefreshIfNeeded refreshes the cache if the TTL has expired
-// Returns true if the cache needed refreshing
-func (c *Cache) RefreshIfNeeded() bool {
+// MarkRefreshed records that the cache was successfully refreshed from the source
+func (c *Cache) MarkRefreshed() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	
+	c.lastRefreshTime = time.Now()
+}
+
+// LastRefresh returns the time of the last successful refresh.
+// The zero time means the cache has never been refreshed.
+func (c *Cache) LastRefresh() time.Time {
 	c.mutex.RLock()
-	needsRefresh := time.Since(c.lastRefreshTime) > c.ttl
-	c.mutex.RUnlock()
-	
-	if needsRefresh {
-		c.logger.Debug("Cache TTL expired, refreshing", 
-			zap.Duration("ttl", c.ttl),
-			zap.Time("lastRefresh", c.lastRefreshTime))
-		c.ClearCache()
-		return true
-	}
+	defer c.mutex.RUnlock()
 	
-	return false
+	return c.lastRefreshTime
 }
 
-// BulkLoadUsers loads multiple users into the cache at once
-// Note: For JWT implementation, this is now primarily used for pre-warming the role cache
-// Individual users are cached on-demand as they authenticate
-func (c *Cache) BulkLoadUsers(users []pocketbase.User) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+// IsStale reports whether the last successful refresh is older than the TTL
+func (c *Cache) IsStale() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	
-	// Since we can't pre-cache users by JWT tokens (they're dynamic),
-	// we primarily load roles here, but still track active user count
-	activeUserCount := 0
+	return time.Since(c.lastRefreshTime) > c.ttl
+}
+
+// BulkLoadUsers reconciles cached tokens with a complete list of users.
+// Users can't be pre-cached by token (tokens are dynamic), but cached
+// entries are updated with the fresh user record, and entries for users
+// that are no longer present or active are dropped.
+func (c *Cache) BulkLoadUsers(users []pocketbase.User) {
+	activeUsers := make(map[string]*pocketbase.User, len(users))
 	for i := range users {
 		if users[i].Active {
-			activeUserCount++
+			activeUsers[users[i].ID] = &users[i]
 		}
 	}
 	
-	c.logger.Debug("Processed active users", zap.Int("count", activeUserCount))
+	c.ReconcileUsers(nil, activeUsers)
+}
+
+// TrackedUserIDs returns the IDs of all users that currently have cached tokens.
+// A refresh only needs fresh records for these users.
+func (c *Cache) TrackedUserIDs() map[string]bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	
+	tracked := make(map[string]bool)
+	c.userCache.Range(func(_ string, user *pocketbase.User, _ time.Time) {
+		tracked[user.ID] = true
+	})
+	return tracked
 }
 
-// BulkLoadRoles loads multiple roles into the cache at once
-func (c *Cache) BulkLoadRoles(roles []pocketbase.Role) {
+// ReconcileUsers updates cached tokens of the tracked users with their fresh
+// active records and drops tokens of tracked users missing from activeUsers.
+// Tokens of users outside tracked (e.g. cached while the refresh was running)
+// are left alone. A nil tracked set means every cached user was checked.
+func (c *Cache) ReconcileUsers(tracked map[string]bool, activeUsers map[string]*pocketbase.User) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 	
+	// Drop expired entries, then reconcile the rest against the fresh records
+	now := time.Now()
+	c.rejectedTokens.RemoveExpired(now)
+	expired := c.userCache.RemoveExpired(now)
+	removed := c.userCache.Update(func(_ string, user *pocketbase.User) (*pocketbase.User, bool) {
+		if tracked != nil && !tracked[user.ID] {
+			return user, true
+		}
+		fresh, found := activeUsers[user.ID]
+		return fresh, found
+	})
+	
+	c.logger.Debug("Reconciled cached users", 
+		zap.Int("active_checked", len(activeUsers)),
+		zap.Int("expired_tokens", expired),
+		zap.Int("revoked_tokens", removed))
+}
+
+// BulkLoadRoles replaces the cached roles with the given set and resolves
+// the role hierarchy. The swap is atomic, so readers never observe an
+// empty or partially loaded role cache. Inheritance cycles and invalid
+// permissions are returned as a joined error; affected roles are still
+// cached with the permissions that could be resolved.
+func (c *Cache) BulkLoadRoles(roles []pocketbase.Role) error {
+	roleCache := make(map[string]*pocketbase.Role, len(roles))
 	for i := range roles {
-		c.roleCache[roles[i].ID] = &roles[i]
+		roleCache[roles[i].ID] = &roles[i]
 	}
 	
-	c.logger.Debug("Bulk loaded roles into cache", zap.Int("count", len(roles)))
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	
+	c.roleCache = roleCache
+	
+	// Resolve inherited permissions for every role
+	errs := c.resolveAllLocked()
+	
+	c.logger.Debug("Bulk loaded roles into cache", 
+		zap.Int("count", len(roles)),
+		zap.Int("hierarchy_errors", len(errs)))
+	
+	return errors.Join(errs...)
+}
+
+// TokenEntry is a cached token, identified by its hash, with the user it belongs to
+type TokenEntry struct {
+	Hash      string           `json:"hash"`
+	User      *pocketbase.User `json:"user"`
+	ExpiresAt time.Time        `json:"expiresAt"`
+}
+
+// Tokens returns all unexpired cached tokens, most recently used first
+func (c *Cache) Tokens() []TokenEntry {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	
+	now := time.Now()
+	entries := make([]TokenEntry, 0, c.userCache.Len())
+	c.userCache.Range(func(hash string, user *pocketbase.User, expiresAt time.Time) {
+		if now.Before(expiresAt) {
+			entries = append(entries, TokenEntry{Hash: hash, User: user, ExpiresAt: expiresAt})
+		}
+	})
+	return entries
+}
+
+// RestoreTokens adds previously exported tokens to the cache, skipping expired ones.
+// Entries are expected most recently used first, as returned by Tokens.
+func (c *Cache) RestoreTokens(entries []TokenEntry) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	
+	// Add in reverse so the most recently used entry ends up in front
+	now := time.Now()
+	restored := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.User == nil || entry.Hash == "" || !now.Before(entry.ExpiresAt) {
+			continue
+		}
+		c.userCache.Add(entry.Hash, entry.User, entry.ExpiresAt)
+		restored++
+	}
+	return restored
+}
+
+// Roles returns all cached roles
+func (c *Cache) Roles() []pocketbase.Role {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	
+	roles := make([]pocketbase.Role, 0, len(c.roleCache))
+	for _, role := range c.roleCache {
+		roles = append(roles, *role)
+	}
+	return roles
 }
 
 // GetStats returns statistics about the cache
@@ -153,7 +439,8 @@ func (c *Cache) GetStats() map[string]int {
 	defer c.mutex.RUnlock()
 	
 	return map[string]int{
-		"users": len(c.userCache),
-		"roles": len(c.roleCache),
+		"users":           c.userCache.Len(),
+		"rejected_tokens": c.rejectedTokens.Len(),
+		"roles":           len(c.roleCache),
 	}
 }
@@ -0,0 +1,182 @@
+// Package cache provides in-memory caching for user and role data
+// with automatic expiration to minimize database lookups
+package cache
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EffectivePermissions holds a role's permissions merged with those
+// inherited from its ancestors through the parent relation
+type EffectivePermissions struct {
+	RoleID    string
+	RoleName  string
+	Publish   []string
+	Subscribe []string
+
+	// Ancestors lists the names of inherited roles, nearest parent first
+	Ancestors []string
+
+	// InheritedPublish and InheritedSubscribe map each inherited pattern
+	// to the name of the ancestor role that contributed it
+	InheritedPublish   map[string]string
+	InheritedSubscribe map[string]string
+}
+
+// CycleError reports a cycle in the role parent relation
+type CycleError struct {
+	// Path lists the role IDs forming the cycle, starting and ending with the same ID
+	Path []string
+}
+
+// Error implements the error interface
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("role inheritance cycle: %s", strings.Join(e.Path, " -> "))
+}
+
+// EffectivePermissions returns the permissions of a role including everything
+// inherited from its ancestors. Ancestors missing from the cache are skipped.
+// Returns nil if the role itself is not in the cache.
+func (c *Cache) EffectivePermissions(roleID string) (*EffectivePermissions, error) {
+	c.mutex.RLock()
+	effective, found := c.effectiveCache[roleID]
+	c.mutex.RUnlock()
+	if found {
+		return effective, nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	effective, _, err := c.resolveLocked(roleID)
+	return effective, err
+}
+
+// resolveAllLocked recomputes effective permissions for every cached role
+// and returns one CycleError per distinct cycle found. Caller must hold the write lock.
+func (c *Cache) resolveAllLocked() []error {
+	c.effectiveCache = make(map[string]*EffectivePermissions, len(c.roleCache))
+
+	var errs []error
+	reported := make(map[string]bool)
+	for id := range c.roleCache {
+		_, cycle, err := c.resolveLocked(id)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if cycle == nil {
+			continue
+		}
+
+		// Report each cycle once, regardless of which member it was found from
+		key := cycleKey(cycle.Path)
+		if !reported[key] {
+			reported[key] = true
+			errs = append(errs, cycle)
+		}
+	}
+
+	return errs
+}
+
+// resolveLocked walks the parent chain of a role and merges permissions.
+// A cycle stops the walk at the first repeated role; the partial result is
+// still cached so that requests keep working while the data is fixed.
+// Caller must hold the write lock.
+func (c *Cache) resolveLocked(roleID string) (*EffectivePermissions, *CycleError, error) {
+	role, found := c.roleCache[roleID]
+	if !found {
+		return nil, nil, nil
+	}
+
+	effective := &EffectivePermissions{
+		RoleID:             role.ID,
+		RoleName:           role.Name,
+		InheritedPublish:   make(map[string]string),
+		InheritedSubscribe: make(map[string]string),
+	}
+	seenPublish := make(map[string]bool)
+	seenSubscribe := make(map[string]bool)
+
+	var cycle *CycleError
+	visited := map[string]bool{}
+	path := []string{}
+
+	for current := role; current != nil; {
+		if visited[current.ID] {
+			// Report only the loop itself, not the roles leading into it
+			loopStart := 0
+			for i, id := range path {
+				if id == current.ID {
+					loopStart = i
+					break
+				}
+			}
+			loop := append([]string(nil), path[loopStart:]...)
+			cycle = &CycleError{Path: append(loop, current.ID)}
+			break
+		}
+		visited[current.ID] = true
+		path = append(path, current.ID)
+
+		inherited := current.ID != role.ID
+		if inherited {
+			effective.Ancestors = append(effective.Ancestors, current.Name)
+		}
+
+		publish, err := current.GetPublishPermissions()
+		if err != nil {
+			return nil, nil, fmt.Errorf("role %s: invalid publish permissions: %w", current.Name, err)
+		}
+		for _, pattern := range publish {
+			if seenPublish[pattern] {
+				continue
+			}
+			seenPublish[pattern] = true
+			effective.Publish = append(effective.Publish, pattern)
+			if inherited {
+				effective.InheritedPublish[pattern] = current.Name
+			}
+		}
+
+		subscribe, err := current.GetSubscribePermissions()
+		if err != nil {
+			return nil, nil, fmt.Errorf("role %s: invalid subscribe permissions: %w", current.Name, err)
+		}
+		for _, pattern := range subscribe {
+			if seenSubscribe[pattern] {
+				continue
+			}
+			seenSubscribe[pattern] = true
+			effective.Subscribe = append(effective.Subscribe, pattern)
+			if inherited {
+				effective.InheritedSubscribe[pattern] = current.Name
+			}
+		}
+
+		// Move on to the parent, if it is known
+		if current.ParentID == "" {
+			break
+		}
+		current = c.roleCache[current.ParentID]
+	}
+
+	c.effectiveCache[roleID] = effective
+	return effective, cycle, nil
+}
+
+// cycleKey returns an order-independent key identifying the members of a cycle
+func cycleKey(path []string) string {
+	// The last element repeats the first; rotate so the smallest ID comes first
+	members := path[:len(path)-1]
+	smallest := 0
+	for i := range members {
+		if members[i] < members[smallest] {
+			smallest = i
+		}
+	}
+	rotated := append(append([]string(nil), members[smallest:]...), members[:smallest]...)
+	return strings.Join(rotated, ",")
+}
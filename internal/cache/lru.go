@@ -0,0 +1,138 @@
+// Package cache provides in-memory caching for user and role data
+// with automatic expiration to minimize database lookups
+package cache
+
+import (
+	"container/list"
+	"time"
+)
+
+// expiringLRU is a size-bounded map whose entries also carry their own expiry.
+// It is not safe for concurrent use; callers guard it with the cache mutex.
+type expiringLRU[V any] struct {
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List // Front is most recently used
+}
+
+// lruEntry is a single value stored in an expiringLRU
+type lruEntry[V any] struct {
+	key       string
+	value     V
+	expiresAt time.Time
+}
+
+// newExpiringLRU creates an LRU holding at most capacity entries
+func newExpiringLRU[V any](capacity int) *expiringLRU[V] {
+	return &expiringLRU[V]{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the value for key if present and not expired.
+// Expired entries are removed on access.
+func (l *expiringLRU[V]) Get(key string, now time.Time) (V, bool) {
+	var zero V
+
+	element, found := l.items[key]
+	if !found {
+		return zero, false
+	}
+
+	entry := element.Value.(*lruEntry[V])
+	if !now.Before(entry.expiresAt) {
+		l.removeElement(element)
+		return zero, false
+	}
+
+	l.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// Add inserts or replaces a value and returns the number of entries evicted
+// to stay within capacity
+func (l *expiringLRU[V]) Add(key string, value V, expiresAt time.Time) int {
+	if element, found := l.items[key]; found {
+		entry := element.Value.(*lruEntry[V])
+		entry.value = value
+		entry.expiresAt = expiresAt
+		l.order.MoveToFront(element)
+		return 0
+	}
+
+	l.items[key] = l.order.PushFront(&lruEntry[V]{key: key, value: value, expiresAt: expiresAt})
+
+	evicted := 0
+	for l.capacity > 0 && l.order.Len() > l.capacity {
+		l.removeElement(l.order.Back())
+		evicted++
+	}
+	return evicted
+}
+
+// Remove deletes a key if present
+func (l *expiringLRU[V]) Remove(key string) {
+	if element, found := l.items[key]; found {
+		l.removeElement(element)
+	}
+}
+
+// Update calls fn for every entry. If fn returns false the entry is removed,
+// otherwise its value is replaced by the returned value. Returns the number removed.
+func (l *expiringLRU[V]) Update(fn func(key string, value V) (V, bool)) int {
+	removed := 0
+	for element := l.order.Front(); element != nil; {
+		next := element.Next()
+		entry := element.Value.(*lruEntry[V])
+		if value, keep := fn(entry.key, entry.value); keep {
+			entry.value = value
+		} else {
+			l.removeElement(element)
+			removed++
+		}
+		element = next
+	}
+	return removed
+}
+
+// Range calls fn for every entry, most recently used first,
+// without changing recency or removing anything
+func (l *expiringLRU[V]) Range(fn func(key string, value V, expiresAt time.Time)) {
+	for element := l.order.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*lruEntry[V])
+		fn(entry.key, entry.value, entry.expiresAt)
+	}
+}
+
+// RemoveExpired deletes all entries that have expired and returns how many were removed
+func (l *expiringLRU[V]) RemoveExpired(now time.Time) int {
+	removed := 0
+	for element := l.order.Front(); element != nil; {
+		next := element.Next()
+		if !now.Before(element.Value.(*lruEntry[V]).expiresAt) {
+			l.removeElement(element)
+			removed++
+		}
+		element = next
+	}
+	return removed
+}
+
+// Purge removes all entries
+func (l *expiringLRU[V]) Purge() {
+	l.items = make(map[string]*list.Element)
+	l.order.Init()
+}
+
+// Len returns the number of entries, including expired ones not yet removed
+func (l *expiringLRU[V]) Len() int {
+	return l.order.Len()
+}
+
+// removeElement unlinks an element from both the list and the index
+func (l *expiringLRU[V]) removeElement(element *list.Element) {
+	l.order.Remove(element)
+	delete(l.items, element.Value.(*lruEntry[V]).key)
+}
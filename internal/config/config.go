@@ -2,12 +2,20 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"regexp"
 	"strings"
 
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
+
+	"api-gateway/internal/pathnorm"
+	"api-gateway/internal/pocketbase"
+	"api-gateway/internal/store"
 )
 
 // Config represents the application configuration
@@ -15,18 +23,105 @@ type Config struct {
 	Server struct {
 		Host string `mapstructure:"host"`
 		Port int    `mapstructure:"port"`
+		
+		// Connection timeouts of the HTTP servers; 0 disables a timeout
+		ReadHeaderTimeoutSeconds int `mapstructure:"readHeaderTimeoutSeconds"` // Reading the request headers
+		ReadTimeoutSeconds       int `mapstructure:"readTimeoutSeconds"`       // Reading the whole request, including the body
+		WriteTimeoutSeconds      int `mapstructure:"writeTimeoutSeconds"`      // From the end of the request headers to the end of the response
+		IdleTimeoutSeconds       int `mapstructure:"idleTimeoutSeconds"`       // Keep-alive connections waiting for the next request
 	} `mapstructure:"server"`
 	
+	// Request size limits, which routes can override; 0 disables a limit
+	Limits RequestLimits `mapstructure:"limits"`
+	
 	PocketBase struct {
 		URL            string `mapstructure:"url"`
 		ServiceAccount string `mapstructure:"serviceAccount"`
 		ServicePassword string `mapstructure:"servicePassword"`
+		APIToken       string `mapstructure:"apiToken"` // Pre-issued superuser token, alternative to the password
 		UserCollection string `mapstructure:"userCollection"`
 		RoleCollection string `mapstructure:"roleCollection"`
+		
+		// Record listing options used when loading users and roles
+		PerPage    int    `mapstructure:"perPage"`    // Records per page
+		SkipTotal  bool   `mapstructure:"skipTotal"`  // Skip PocketBase's total count query
+		UserFilter string `mapstructure:"userFilter"` // PocketBase filter expression for users
+		UserFields string `mapstructure:"userFields"` // Comma-separated user fields to fetch
+		RoleFilter string `mapstructure:"roleFilter"` // PocketBase filter expression for roles
+		RoleFields string `mapstructure:"roleFields"` // Comma-separated role fields to fetch
+		
+		// Realtime subscription for near-instant cache invalidation
+		Realtime struct {
+			Enabled           bool `mapstructure:"enabled"`
+			MinBackoffSeconds int  `mapstructure:"minBackoffSeconds"`
+			MaxBackoffSeconds int  `mapstructure:"maxBackoffSeconds"`
+		} `mapstructure:"realtime"`
+		
+		// HTTP connection pooling and timeouts
+		Transport struct {
+			DialTimeoutSeconds           int `mapstructure:"dialTimeoutSeconds"`
+			KeepAliveSeconds             int `mapstructure:"keepAliveSeconds"`
+			MaxIdleConns                 int `mapstructure:"maxIdleConns"`
+			MaxIdleConnsPerHost          int `mapstructure:"maxIdleConnsPerHost"`
+			MaxConnsPerHost              int `mapstructure:"maxConnsPerHost"`
+			IdleConnTimeoutSeconds       int `mapstructure:"idleConnTimeoutSeconds"`
+			TLSHandshakeTimeoutSeconds   int `mapstructure:"tlsHandshakeTimeoutSeconds"`
+			ResponseHeaderTimeoutSeconds int `mapstructure:"responseHeaderTimeoutSeconds"`
+			RequestTimeoutSeconds        int `mapstructure:"requestTimeoutSeconds"` // Bounds a single attempt
+		} `mapstructure:"transport"`
+		
+		// Retries of transient failures (network errors, 429, 502, 503, 504)
+		Retry struct {
+			MaxAttempts      int `mapstructure:"maxAttempts"`      // Total attempts including the first
+			InitialBackoffMs int `mapstructure:"initialBackoffMs"` // Delay before the first retry, doubled per retry
+			MaxBackoffMs     int `mapstructure:"maxBackoffMs"`     // Upper bound on the delay between retries
+		} `mapstructure:"retry"`
+		
+		// Collection field names, for schemas that differ from the default
+		FieldMapping struct {
+			User struct {
+				Username string           `mapstructure:"username"`
+				Email    string           `mapstructure:"email"`
+				RoleID   string           `mapstructure:"roleId"`  // Relation to the primary role
+				RoleIDs  string           `mapstructure:"roleIds"` // Optional multi relation to additional roles
+				Active   string           `mapstructure:"active"`
+				Extra    []ExtraUserField `mapstructure:"extra"` // Additional fields for headers and permission templates
+			} `mapstructure:"user"`
+			Role struct {
+				Name                 string `mapstructure:"name"`
+				Parent               string `mapstructure:"parent"`
+				PublishPermissions   string `mapstructure:"publishPermissions"`
+				SubscribePermissions string `mapstructure:"subscribePermissions"`
+			} `mapstructure:"role"`
+			ExpandRoles bool `mapstructure:"expandRoles"` // Fetch the user's roles with expand when validating tokens
+		} `mapstructure:"fieldMapping"`
 	} `mapstructure:"pocketbase"`
 	
+	// Identity store holding users, roles and tokens
+	Store struct {
+		Type string `mapstructure:"type"` // "pocketbase", "file" or "sqlite"
+		File struct {
+			Path string `mapstructure:"path"` // YAML or JSON file with users, roles and hashed API keys
+		} `mapstructure:"file"`
+		SQLite struct {
+			Path string `mapstructure:"path"` // SQLite database file, created if missing
+		} `mapstructure:"sqlite"`
+	} `mapstructure:"store"`
+	
 	Routes          []Route `mapstructure:"routes"`
 	
+	// Header handling for proxied requests
+	Headers struct {
+		// Reserved headers are stripped from every incoming request, so only the gateway can set them.
+		// They add to the identity headers the gateway sets itself, which are always reserved.
+		Reserved []string `mapstructure:"reserved"`
+		
+		// Credential headers mark requests on unprotected routes whose responses may be
+		// personal, so they aren't coalesced or served from others' cached responses.
+		// They add to DefaultCredentialHeaders.
+		Credentials []string `mapstructure:"credentials"`
+	} `mapstructure:"headers"`
+	
 	// Enhanced logging configuration
 	Logging struct {
 		Level     string `mapstructure:"level"`
@@ -39,14 +134,278 @@ type Config struct {
 	} `mapstructure:"logging"`
 	
 	CacheTTLSeconds int `mapstructure:"cacheTTLSeconds"`
+	
+	// Background cache refresh configuration
+	Cache struct {
+		RefreshJitterPercent int `mapstructure:"refreshJitterPercent"` // Random +/- spread applied to the refresh interval
+		RefreshRetrySeconds  int `mapstructure:"refreshRetrySeconds"`  // Delay before retrying a failed refresh
+		MaxTokenTTLSeconds   int `mapstructure:"maxTokenTTLSeconds"`   // Upper bound on how long a validated token is cached
+		MaxTokens            int `mapstructure:"maxTokens"`            // Maximum number of cached tokens (LRU eviction)
+		NegativeTTLSeconds   int `mapstructure:"negativeTTLSeconds"`   // How long rejected tokens are remembered (0 disables)
+		MaxRejectedTokens    int `mapstructure:"maxRejectedTokens"`    // Maximum number of remembered rejected tokens
+	} `mapstructure:"cache"`
+	
+	// Memory shared by the response caches of all routes
+	ResponseCache struct {
+		MaxBytes      int64 `mapstructure:"maxBytes"`      // Total size of cached responses (LRU eviction)
+		MaxEntryBytes int64 `mapstructure:"maxEntryBytes"` // Larger responses are not cached
+	} `mapstructure:"responseCache"`
+	
+	// Last known good snapshot used when the identity store is unavailable at startup
+	Snapshot struct {
+		Path          string `mapstructure:"path"`          // Snapshot file; empty disables snapshots
+		EncryptionKey string `mapstructure:"encryptionKey"` // Base64-encoded AES key (16, 24 or 32 bytes); empty stores plain JSON
+		MaxAgeSeconds int    `mapstructure:"maxAgeSeconds"` // Refuse older snapshots at startup (0 means no limit)
+	} `mapstructure:"snapshot"`
+	
+	// Gateway-signed identity assertion forwarded to upstreams
+	Assertion struct {
+		Enabled        bool   `mapstructure:"enabled"`
+		Header         string `mapstructure:"header"`         // Request header carrying the assertion
+		Algorithm      string `mapstructure:"algorithm"`      // "EdDSA" or "RS256"
+		PrivateKeyFile string `mapstructure:"privateKeyFile"` // PEM private key; empty generates one at startup
+		Issuer         string `mapstructure:"issuer"`
+		Audience       string `mapstructure:"audience"`       // Optional "aud" claim
+		TTLSeconds     int    `mapstructure:"ttlSeconds"`
+	} `mapstructure:"assertion"`
+	
+	// Revoked tokens, rejected even while the identity store accepts them
+	Revocation struct {
+		Path string `mapstructure:"path"` // File persisting the revocation list; empty keeps it in memory only
+	} `mapstructure:"revocation"`
+	
+	// Admin API for cache inspection and invalidation
+	Admin struct {
+		Enabled       bool   `mapstructure:"enabled"`
+		Role          string `mapstructure:"role"`          // Role name required to use /admin on the main listener
+		ListenAddress string `mapstructure:"listenAddress"` // Serve /admin on a separate listener instead, e.g. "127.0.0.1:9090"
+	} `mapstructure:"admin"`
+	
+	// Path canonicalization applied before permission checks and proxying
+	PathNormalization struct {
+		Policy               string `mapstructure:"policy"`               // "normalize" or "reject"
+		DecodeEncodedSlashes bool   `mapstructure:"decodeEncodedSlashes"` // Treat %2F as a separator instead of rejecting it
+	} `mapstructure:"pathNormalization"`
 }
 
 // Route defines a proxy route
 type Route struct {
-	PathPrefix  string `mapstructure:"pathPrefix"`
-	TargetURL   string `mapstructure:"targetUrl"`
-	StripPrefix bool   `mapstructure:"stripPrefix"`
-	Protected   bool   `mapstructure:"protected"`
+	PathPrefix  string `mapstructure:"pathPrefix" json:"pathPrefix"`
+	TargetURL   string `mapstructure:"targetUrl" json:"targetUrl"`
+	StripPrefix bool   `mapstructure:"stripPrefix" json:"stripPrefix"`
+	Protected   bool   `mapstructure:"protected" json:"protected"`
+	
+	// Optional conditions besides the path prefix; all that are set must match
+	Hosts   []string         `mapstructure:"hosts" json:"hosts,omitempty"`     // Host names; "*.example.com" matches subdomains
+	Methods []string         `mapstructure:"methods" json:"methods,omitempty"` // HTTP methods
+	Headers []MatchCondition `mapstructure:"headers" json:"headers,omitempty"` // Request headers
+	Query   []MatchCondition `mapstructure:"query" json:"query,omitempty"`     // Query parameters
+	
+	// Path rewrites; the first matching rule applies instead of stripPrefix
+	Rewrite []RewriteRule `mapstructure:"rewrite" json:"rewrite,omitempty"`
+	
+	// Header rules applied to requests forwarded on this route and to their responses
+	RequestHeaders  HeaderRules `mapstructure:"requestHeaders" json:"requestHeaders"`
+	ResponseHeaders HeaderRules `mapstructure:"responseHeaders" json:"responseHeaders"`
+	
+	// Optional split of the route's traffic between upstream versions
+	Split TrafficSplit `mapstructure:"split" json:"split"`
+	
+	// Optional copy of the route's traffic to a shadow upstream
+	Mirror Mirror `mapstructure:"mirror" json:"mirror"`
+	
+	// Optional caching of the route's GET responses
+	Cache RouteCache `mapstructure:"cache" json:"cache"`
+	
+	// Optional collapsing of identical concurrent GET requests
+	Coalesce Coalesce `mapstructure:"coalesce" json:"coalesce"`
+	
+	// Request size limits replacing the global ones; 0 keeps the global limit
+	Limits RequestLimits `mapstructure:"limits" json:"limits"`
+}
+
+// RequestLimits bounds the size of requests
+type RequestLimits struct {
+	MaxBodyBytes   int64 `mapstructure:"maxBodyBytes" json:"maxBodyBytes,omitempty"`     // Request body; larger requests get 413
+	MaxHeaderBytes int   `mapstructure:"maxHeaderBytes" json:"maxHeaderBytes,omitempty"` // Request headers, names and values; larger requests get 431
+	MaxURLBytes    int   `mapstructure:"maxUrlBytes" json:"maxUrlBytes,omitempty"`       // Request target, path and query; longer requests get 414
+}
+
+// Merge returns the limits with the unset ones taken from defaults
+func (l RequestLimits) Merge(defaults RequestLimits) RequestLimits {
+	if l.MaxBodyBytes == 0 {
+		l.MaxBodyBytes = defaults.MaxBodyBytes
+	}
+	if l.MaxHeaderBytes == 0 {
+		l.MaxHeaderBytes = defaults.MaxHeaderBytes
+	}
+	if l.MaxURLBytes == 0 {
+		l.MaxURLBytes = defaults.MaxURLBytes
+	}
+	return l
+}
+
+// validate checks that no limit is negative
+func (l RequestLimits) validate() error {
+	if l.MaxBodyBytes < 0 || l.MaxHeaderBytes < 0 || l.MaxURLBytes < 0 {
+		return fmt.Errorf("maxBodyBytes, maxHeaderBytes and maxUrlBytes must not be negative")
+	}
+	return nil
+}
+
+// MaxRequestLimits returns the largest limits any request can be allowed:
+// the global limits raised to the largest limit of any route. A limit is 0
+// if requests matching no route aren't limited.
+func (c *Config) MaxRequestLimits() RequestLimits {
+	limits := c.Limits
+	for _, route := range c.Routes {
+		if route.Limits.MaxBodyBytes > limits.MaxBodyBytes {
+			limits.MaxBodyBytes = route.Limits.MaxBodyBytes
+		}
+		if route.Limits.MaxHeaderBytes > limits.MaxHeaderBytes {
+			limits.MaxHeaderBytes = route.Limits.MaxHeaderBytes
+		}
+		if route.Limits.MaxURLBytes > limits.MaxURLBytes {
+			limits.MaxURLBytes = route.Limits.MaxURLBytes
+		}
+	}
+	if c.Limits.MaxBodyBytes == 0 {
+		limits.MaxBodyBytes = 0
+	}
+	if c.Limits.MaxHeaderBytes == 0 {
+		limits.MaxHeaderBytes = 0
+	}
+	if c.Limits.MaxURLBytes == 0 {
+		limits.MaxURLBytes = 0
+	}
+	return limits
+}
+
+// Coalesce sends only one of identical concurrent GET requests upstream and
+// shares its response with the others. Requests are identical if they have
+// the same path, query, content negotiation headers and, on protected
+// routes, the same roles. The upstream still gets the identity headers and
+// assertion of the user whose request went first, so its responses must
+// not depend on the individual user.
+type Coalesce struct {
+	Enabled      bool  `mapstructure:"enabled" json:"enabled"`
+	MaxBodyBytes int64 `mapstructure:"maxBodyBytes" json:"maxBodyBytes,omitempty"` // Larger responses aren't shared; 0 for DefaultCoalesceMaxBodyBytes
+}
+
+// DefaultCoalesceMaxBodyBytes is the largest response shared by default
+const DefaultCoalesceMaxBodyBytes = 1 << 20
+
+// Response cache partitions
+const (
+	CachePartitionRole = "role" // Shared by users holding the same roles
+	CachePartitionUser = "user" // Kept per user
+)
+
+// RouteCache caches a route's GET responses as far as the upstream's
+// Cache-Control allows. On protected routes, responses are only shared
+// within a partition; on unprotected routes they are shared by everyone.
+// With CachePartitionRole, the upstream gets the identity headers and
+// assertion of the user whose request was cached, so its responses must
+// not depend on the individual user unless marked private.
+type RouteCache struct {
+	Enabled    bool   `mapstructure:"enabled" json:"enabled"`
+	Partition  string `mapstructure:"partition" json:"partition,omitempty"`   // CachePartitionRole (default) or CachePartitionUser
+	TTLSeconds int    `mapstructure:"ttlSeconds" json:"ttlSeconds,omitempty"` // Freshness of 200 responses without max-age or Expires; 0 doesn't cache them
+}
+
+// TrafficSplit sends each request on a route to one of its variants. A
+// variant is chosen by the override header or cookie, then by the user's ID
+// or roles, then by weight. Weighted assignment is sticky per user ID;
+// anonymous requests are assigned at random.
+type TrafficSplit struct {
+	Variants []SplitVariant `mapstructure:"variants" json:"variants,omitempty"`
+	Header   string         `mapstructure:"header" json:"header,omitempty"` // Request header naming the variant to use, for testers to opt in
+	Cookie   string         `mapstructure:"cookie" json:"cookie,omitempty"` // Cookie naming the variant to use
+}
+
+// SplitVariant is a version of a route's upstream
+type SplitVariant struct {
+	Name      string   `mapstructure:"name" json:"name"`
+	TargetURL string   `mapstructure:"targetUrl" json:"targetUrl,omitempty"` // Defaults to the route's targetUrl
+	Weight    int      `mapstructure:"weight" json:"weight"`                 // Share of weighted traffic, relative to the other variants
+	Users     []string `mapstructure:"users" json:"users,omitempty"`         // User IDs always sent to this variant
+	Roles     []string `mapstructure:"roles" json:"roles,omitempty"`         // Role names whose holders are sent to this variant
+}
+
+// MatchCondition requires a header or query parameter
+type MatchCondition struct {
+	Name  string `mapstructure:"name" json:"name"`
+	Value string `mapstructure:"value" json:"value,omitempty"` // Empty matches any value, as long as it is present
+}
+
+// RewriteRule rewrites the path of matching requests. Path and Query are
+// templates that refer to named captures of Match as ${name}.
+type RewriteRule struct {
+	Match string `mapstructure:"match" json:"match"` // Regular expression matched against the request path
+	Path  string `mapstructure:"path" json:"path"`   // New upstream path, e.g. "/v1/device"
+	Query string `mapstructure:"query" json:"query"` // Query parameters to set, e.g. "id=${id}"
+}
+
+// HeaderRules modifies headers. Removals are applied first, then renames, then additions.
+type HeaderRules struct {
+	Add    map[string]string `mapstructure:"add" json:"add,omitempty"`       // Header -> value, replacing any existing value; values may use templates, see validateHeaderRules
+	Remove []string          `mapstructure:"remove" json:"remove,omitempty"` // Headers to drop
+	Rename map[string]string `mapstructure:"rename" json:"rename,omitempty"` // Old name -> new name
+}
+
+// Mirror copies sampled requests to a shadow upstream. The client always
+// gets the primary upstream's response; the shadow's is only compared with it.
+type Mirror struct {
+	TargetURL      string  `mapstructure:"targetUrl" json:"targetUrl,omitempty"`
+	SampleRate     float64 `mapstructure:"sampleRate" json:"sampleRate,omitempty"`         // Share of requests to copy, above 0 and up to 1
+	MaxBodyBytes   int64   `mapstructure:"maxBodyBytes" json:"maxBodyBytes,omitempty"`     // Requests with larger bodies aren't copied; 0 for DefaultMirrorMaxBodyBytes
+	TimeoutSeconds int     `mapstructure:"timeoutSeconds" json:"timeoutSeconds,omitempty"` // Timeout of shadow requests; 0 for DefaultMirrorTimeoutSeconds
+	MaxInFlight    int     `mapstructure:"maxInFlight" json:"maxInFlight,omitempty"`       // Shadow requests in flight before further copies are dropped; 0 for DefaultMirrorMaxInFlight
+}
+
+// Mirror defaults, applied per route when a setting is 0
+const (
+	DefaultMirrorMaxBodyBytes   = 64 << 10
+	DefaultMirrorTimeoutSeconds = 10
+	DefaultMirrorMaxInFlight    = 100
+)
+
+// DefaultReservedHeaders are the identity headers the gateway sets itself,
+// reserved whatever headers.reserved adds
+var DefaultReservedHeaders = []string{
+	"X-User-ID",
+	"X-Username",
+	"X-Role-ID",
+	"X-Role-Name",
+	"X-Role-IDs",
+	"X-Role-Names",
+}
+
+// DefaultCredentialHeaders are the request headers always treated as
+// carrying credentials, whatever headers.credentials adds
+var DefaultCredentialHeaders = []string{
+	"Authorization",
+	"Cookie",
+}
+
+// ExtraUserField is an additional user field made available to the gateway
+type ExtraUserField struct {
+	Field  string `mapstructure:"field"`  // Field name in the user collection
+	Header string `mapstructure:"header"` // Header to forward the value in; empty to not forward it
+}
+
+// DecodeSnapshotKey decodes the base64-encoded snapshot encryption key
+func DecodeSnapshotKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot.encryptionKey must be base64-encoded: %w", err)
+	}
+	
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return nil, fmt.Errorf("snapshot.encryptionKey must decode to 16, 24 or 32 bytes, got %d", len(key))
+	}
+	
+	return key, nil
 }
 
 // LoadConfig loads the application configuration from file and environment variables
@@ -56,8 +415,48 @@ func LoadConfig(configPath string, logger *zap.Logger) (*Config, error) {
 	// Set default values
 	v.SetDefault("server.host", "0.0.0.0")
 	v.SetDefault("server.port", 9000)
+	v.SetDefault("server.readHeaderTimeoutSeconds", 10)
+	v.SetDefault("server.readTimeoutSeconds", 60)
+	v.SetDefault("server.writeTimeoutSeconds", 0)
+	v.SetDefault("server.idleTimeoutSeconds", 120)
+	
+	// Default request limits
+	v.SetDefault("limits.maxBodyBytes", 10<<20)
+	v.SetDefault("limits.maxHeaderBytes", 64<<10)
+	v.SetDefault("limits.maxUrlBytes", 8<<10)
+	v.SetDefault("store.type", "pocketbase")
+	v.SetDefault("store.file.path", "")
+	v.SetDefault("store.sqlite.path", "")
 	v.SetDefault("pocketbase.userCollection", "users")
 	v.SetDefault("pocketbase.roleCollection", "mqtt_roles")
+	v.SetDefault("pocketbase.apiToken", "")
+	v.SetDefault("pocketbase.perPage", 200)
+	v.SetDefault("pocketbase.skipTotal", true)
+	v.SetDefault("pocketbase.realtime.enabled", true)
+	v.SetDefault("pocketbase.realtime.minBackoffSeconds", 1)
+	v.SetDefault("pocketbase.realtime.maxBackoffSeconds", 60)
+	v.SetDefault("pocketbase.transport.dialTimeoutSeconds", 5)
+	v.SetDefault("pocketbase.transport.keepAliveSeconds", 30)
+	v.SetDefault("pocketbase.transport.maxIdleConns", 100)
+	v.SetDefault("pocketbase.transport.maxIdleConnsPerHost", 10)
+	v.SetDefault("pocketbase.transport.maxConnsPerHost", 20)
+	v.SetDefault("pocketbase.transport.idleConnTimeoutSeconds", 90)
+	v.SetDefault("pocketbase.transport.tlsHandshakeTimeoutSeconds", 5)
+	v.SetDefault("pocketbase.transport.responseHeaderTimeoutSeconds", 5)
+	v.SetDefault("pocketbase.transport.requestTimeoutSeconds", 10)
+	v.SetDefault("pocketbase.retry.maxAttempts", 3)
+	v.SetDefault("pocketbase.retry.initialBackoffMs", 100)
+	v.SetDefault("pocketbase.retry.maxBackoffMs", 2000)
+	v.SetDefault("pocketbase.fieldMapping.user.username", "username")
+	v.SetDefault("pocketbase.fieldMapping.user.email", "email")
+	v.SetDefault("pocketbase.fieldMapping.user.roleId", "role_id")
+	v.SetDefault("pocketbase.fieldMapping.user.roleIds", "role_ids")
+	v.SetDefault("pocketbase.fieldMapping.user.active", "active")
+	v.SetDefault("pocketbase.fieldMapping.role.name", "name")
+	v.SetDefault("pocketbase.fieldMapping.role.parent", "parent")
+	v.SetDefault("pocketbase.fieldMapping.role.publishPermissions", "publish_permissions")
+	v.SetDefault("pocketbase.fieldMapping.role.subscribePermissions", "subscribe_permissions")
+	v.SetDefault("pocketbase.fieldMapping.expandRoles", false)
 	
 	// Default logging configuration
 	v.SetDefault("logging.level", "info")
@@ -69,6 +468,44 @@ func LoadConfig(configPath string, logger *zap.Logger) (*Config, error) {
 	v.SetDefault("logging.compress", true)
 	
 	v.SetDefault("cacheTTLSeconds", 300)
+	v.SetDefault("cache.refreshJitterPercent", 10)
+	v.SetDefault("cache.refreshRetrySeconds", 15)
+	v.SetDefault("cache.maxTokenTTLSeconds", 300)
+	v.SetDefault("cache.maxTokens", 10000)
+	v.SetDefault("cache.negativeTTLSeconds", 10)
+	v.SetDefault("cache.maxRejectedTokens", 10000)
+	
+	// Default response cache configuration
+	v.SetDefault("responseCache.maxBytes", 64<<20)
+	v.SetDefault("responseCache.maxEntryBytes", 1<<20)
+	
+	// Default snapshot configuration
+	v.SetDefault("snapshot.path", "")
+	v.SetDefault("snapshot.encryptionKey", "")
+	v.SetDefault("snapshot.maxAgeSeconds", 0)
+	
+	// Default header configuration
+	
+	// Default identity assertion configuration
+	v.SetDefault("assertion.enabled", false)
+	v.SetDefault("assertion.header", "X-Gateway-Assertion")
+	v.SetDefault("assertion.algorithm", "EdDSA")
+	v.SetDefault("assertion.privateKeyFile", "")
+	v.SetDefault("assertion.issuer", "api-gateway")
+	v.SetDefault("assertion.audience", "")
+	v.SetDefault("assertion.ttlSeconds", 60)
+	
+	// Default revocation list configuration
+	v.SetDefault("revocation.path", "")
+	
+	// Default admin API configuration
+	v.SetDefault("admin.enabled", false)
+	v.SetDefault("admin.role", "")
+	v.SetDefault("admin.listenAddress", "")
+	
+	// Default path normalization configuration
+	v.SetDefault("pathNormalization.policy", "normalize")
+	v.SetDefault("pathNormalization.decodeEncodedSlashes", false)
 	
 	// Configure file path
 	if configPath != "" {
@@ -110,23 +547,95 @@ func LoadConfig(configPath string, logger *zap.Logger) (*Config, error) {
 		return nil, err
 	}
 	
+	// Overlapping routes are resolved by specificity, which may not be what was meant
+	for _, warning := range ShadowedRoutes(config.Routes) {
+		logger.Warn("Overlapping routes", zap.String("detail", warning))
+	}
+	
 	return &config, nil
 }
 
 // validateConfig checks if the configuration is valid
 func validateConfig(config *Config) error {
-	// Check PocketBase URL
-	if config.PocketBase.URL == "" {
-		return fmt.Errorf("pocketbase.url is required")
+	// Check the identity store
+	switch config.Store.Type {
+	case store.TypePocketBase:
+		if err := validatePocketBase(config); err != nil {
+			return err
+		}
+	case store.TypeFile:
+		if config.Store.File.Path == "" {
+			return fmt.Errorf("store.file.path is required")
+		}
+	case store.TypeSQLite:
+		if config.Store.SQLite.Path == "" {
+			return fmt.Errorf("store.sqlite.path is required")
+		}
+	default:
+		return fmt.Errorf("store.type must be %q, %q or %q", store.TypePocketBase, store.TypeFile, store.TypeSQLite)
+	}
+	
+	// Check extra user fields
+	for i, extra := range config.PocketBase.FieldMapping.User.Extra {
+		if extra.Field == "" {
+			return fmt.Errorf("pocketbase.fieldMapping.user.extra[%d].field is required", i)
+		}
+		
+		if extra.Header != "" && strings.ContainsAny(extra.Header, " :\r\n") {
+			return fmt.Errorf("pocketbase.fieldMapping.user.extra[%d].header is not a valid header name", i)
+		}
 	}
 	
-	// Check PocketBase credentials
-	if config.PocketBase.ServiceAccount == "" {
-		return fmt.Errorf("pocketbase.serviceAccount is required")
+	// Check snapshot settings
+	if config.Snapshot.EncryptionKey != "" {
+		if _, err := DecodeSnapshotKey(config.Snapshot.EncryptionKey); err != nil {
+			return err
+		}
 	}
 	
-	if config.PocketBase.ServicePassword == "" {
-		return fmt.Errorf("pocketbase.servicePassword is required")
+	if config.Snapshot.MaxAgeSeconds < 0 {
+		return fmt.Errorf("snapshot.maxAgeSeconds must not be negative")
+	}
+	
+	// Check identity assertion settings
+	if config.Assertion.Enabled {
+		if config.Assertion.Header == "" || strings.ContainsAny(config.Assertion.Header, " :\r\n") {
+			return fmt.Errorf("assertion.header must be a valid header name")
+		}
+		
+		if config.Assertion.Algorithm != "EdDSA" && config.Assertion.Algorithm != "RS256" {
+			return fmt.Errorf("assertion.algorithm must be EdDSA or RS256")
+		}
+		
+		if config.Assertion.TTLSeconds <= 0 {
+			return fmt.Errorf("assertion.ttlSeconds must be positive")
+		}
+	}
+	
+	// Check header settings
+	for i, name := range config.Headers.Reserved {
+		if !validHeaderName(name) {
+			return fmt.Errorf("headers.reserved[%d] is not a valid header name", i)
+		}
+	}
+	for i, name := range config.Headers.Credentials {
+		if !validHeaderName(name) {
+			return fmt.Errorf("headers.credentials[%d] is not a valid header name", i)
+		}
+	}
+	
+	// Check server timeouts and request limits
+	if config.Server.ReadHeaderTimeoutSeconds < 0 || config.Server.ReadTimeoutSeconds < 0 ||
+		config.Server.WriteTimeoutSeconds < 0 || config.Server.IdleTimeoutSeconds < 0 {
+		return fmt.Errorf("server timeouts must not be negative")
+	}
+	if err := config.Limits.validate(); err != nil {
+		return fmt.Errorf("limits: %w", err)
+	}
+	
+	// Check admin API settings
+	if config.Admin.Enabled && config.Admin.Role == "" && config.Admin.ListenAddress == "" {
+		return fmt.Errorf("admin.role or admin.listenAddress is required when the admin API is enabled")
 	}
 	
 	// Check if at least one route is defined
@@ -144,6 +653,58 @@ func validateConfig(config *Config) error {
 			return fmt.Errorf("routes[%d].targetUrl is required", i)
 		}
 		
+		// The admin API owns /admin when it shares the main listener
+		if config.Admin.Enabled && config.Admin.ListenAddress == "" &&
+			(route.PathPrefix == "/admin" || strings.HasPrefix(route.PathPrefix, "/admin/")) {
+			return fmt.Errorf("routes[%d].pathPrefix %s conflicts with the admin API", i, route.PathPrefix)
+		}
+		
+		if err := validateRouteConditions(route); err != nil {
+			return fmt.Errorf("routes[%d]: %w", i, err)
+		}
+		
+		// Routes with the same prefix must not both match a request, unless one is more specific
+		for j := 0; j < i; j++ {
+			other := config.Routes[j]
+			if other.PathPrefix == route.PathPrefix && other.Specificity() == route.Specificity() && routesOverlap(&other, &route) {
+				return fmt.Errorf("routes[%d] and routes[%d] both match some requests to %s; add or change hosts, methods, headers or query so one is more specific or they don't overlap", j, i, route.PathPrefix)
+			}
+		}
+		
+		for j, rule := range route.Rewrite {
+			if err := ValidateRewriteRule(rule); err != nil {
+				return fmt.Errorf("routes[%d].rewrite[%d]: %w", i, j, err)
+			}
+		}
+		
+		if err := validateHeaderRules(route.RequestHeaders); err != nil {
+			return fmt.Errorf("routes[%d].requestHeaders: %w", i, err)
+		}
+		
+		if err := validateHeaderRules(route.ResponseHeaders); err != nil {
+			return fmt.Errorf("routes[%d].responseHeaders: %w", i, err)
+		}
+		
+		if err := validateTrafficSplit(route.Split); err != nil {
+			return fmt.Errorf("routes[%d].split: %w", i, err)
+		}
+		
+		if err := validateMirror(route.Mirror); err != nil {
+			return fmt.Errorf("routes[%d].mirror: %w", i, err)
+		}
+		
+		if err := validateRouteCache(route); err != nil {
+			return fmt.Errorf("routes[%d].cache: %w", i, err)
+		}
+		
+		if err := validateCoalesce(route); err != nil {
+			return fmt.Errorf("routes[%d].coalesce: %w", i, err)
+		}
+		
+		if err := route.Limits.validate(); err != nil {
+			return fmt.Errorf("routes[%d].limits: %w", i, err)
+		}
+		
 		// For backward compatibility, routes are protected by default if not specified
 		if !route.Protected {
 			// This is not an error, just log it for visibility that the route is intentionally unprotected
@@ -151,7 +712,12 @@ func validateConfig(config *Config) error {
 			fmt.Printf("Route %s is configured as unprotected\n", route.PathPrefix)
 		}
 	}	
-
+	
+	// Check response cache settings
+	if config.ResponseCache.MaxBytes <= 0 || config.ResponseCache.MaxEntryBytes <= 0 {
+		return fmt.Errorf("responseCache.maxBytes and responseCache.maxEntryBytes must be positive")
+	}
+	
 	// Validate logging configuration
 	if len(config.Logging.Outputs) == 0 {
 		return fmt.Errorf("at least one logging output must be specified")
@@ -164,9 +730,376 @@ func validateConfig(config *Config) error {
 		}
 	}
 	
+	// Validate cache refresh configuration
+	if config.CacheTTLSeconds <= 0 {
+		return fmt.Errorf("cacheTTLSeconds must be positive")
+	}
+	
+	if config.Cache.RefreshJitterPercent < 0 || config.Cache.RefreshJitterPercent > 50 {
+		return fmt.Errorf("cache.refreshJitterPercent must be between 0 and 50")
+	}
+	
+	if config.Cache.RefreshRetrySeconds <= 0 {
+		return fmt.Errorf("cache.refreshRetrySeconds must be positive")
+	}
+	
+	if config.Cache.MaxTokenTTLSeconds <= 0 {
+		return fmt.Errorf("cache.maxTokenTTLSeconds must be positive")
+	}
+	
+	if config.Cache.MaxTokens <= 0 {
+		return fmt.Errorf("cache.maxTokens must be positive")
+	}
+	
+	if config.Cache.NegativeTTLSeconds < 0 {
+		return fmt.Errorf("cache.negativeTTLSeconds must not be negative")
+	}
+	
+	if config.Cache.MaxRejectedTokens <= 0 {
+		return fmt.Errorf("cache.maxRejectedTokens must be positive")
+	}
+	
+	// Validate path normalization policy
+	if _, err := pathnorm.ParsePolicy(config.PathNormalization.Policy); err != nil {
+		return fmt.Errorf("pathNormalization.policy: %w", err)
+	}
+	
+	return nil
+}
+
+// validatePocketBase checks the settings of the PocketBase store
+func validatePocketBase(config *Config) error {
+	// Check PocketBase URL
+	if config.PocketBase.URL == "" {
+		return fmt.Errorf("pocketbase.url is required")
+	}
+	
+	// Check PocketBase credentials; an API token replaces the account and password
+	if config.PocketBase.APIToken == "" {
+		if config.PocketBase.ServiceAccount == "" {
+			return fmt.Errorf("pocketbase.serviceAccount is required")
+		}
+		
+		if config.PocketBase.ServicePassword == "" {
+			return fmt.Errorf("pocketbase.servicePassword is required")
+		}
+	}
+	
+	// Check listing page size
+	if config.PocketBase.PerPage <= 0 || config.PocketBase.PerPage > pocketbase.MaxPerPage {
+		return fmt.Errorf("pocketbase.perPage must be between 1 and %d", pocketbase.MaxPerPage)
+	}
+	
+	// Check realtime backoff settings
+	if config.PocketBase.Realtime.Enabled {
+		if config.PocketBase.Realtime.MinBackoffSeconds <= 0 {
+			return fmt.Errorf("pocketbase.realtime.minBackoffSeconds must be positive")
+		}
+		
+		if config.PocketBase.Realtime.MaxBackoffSeconds < config.PocketBase.Realtime.MinBackoffSeconds {
+			return fmt.Errorf("pocketbase.realtime.maxBackoffSeconds must not be less than minBackoffSeconds")
+		}
+	}
+	
+	// Check transport settings
+	transport := config.PocketBase.Transport
+	if transport.DialTimeoutSeconds <= 0 || transport.KeepAliveSeconds <= 0 ||
+		transport.IdleConnTimeoutSeconds <= 0 || transport.TLSHandshakeTimeoutSeconds <= 0 ||
+		transport.ResponseHeaderTimeoutSeconds <= 0 || transport.RequestTimeoutSeconds <= 0 {
+		return fmt.Errorf("pocketbase.transport timeouts must be positive")
+	}
+	
+	if transport.MaxIdleConns <= 0 || transport.MaxIdleConnsPerHost <= 0 || transport.MaxConnsPerHost <= 0 {
+		return fmt.Errorf("pocketbase.transport connection limits must be positive")
+	}
+	
+	// Check retry settings
+	if config.PocketBase.Retry.MaxAttempts <= 0 {
+		return fmt.Errorf("pocketbase.retry.maxAttempts must be positive")
+	}
+	
+	if config.PocketBase.Retry.InitialBackoffMs <= 0 {
+		return fmt.Errorf("pocketbase.retry.initialBackoffMs must be positive")
+	}
+	
+	if config.PocketBase.Retry.MaxBackoffMs < config.PocketBase.Retry.InitialBackoffMs {
+		return fmt.Errorf("pocketbase.retry.maxBackoffMs must not be less than initialBackoffMs")
+	}
+	
+	return nil
+}
+
+// templatePlaceholder matches ${...} placeholders in header values and rewrite templates
+var templatePlaceholder = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// validHeaderName reports whether name can be used as a header name
+func validHeaderName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " :\t\r\n")
+}
+
+// ValidateRewriteRule checks that a rewrite rule's expression compiles and
+// that its templates only refer to named captures of the expression
+func ValidateRewriteRule(rule RewriteRule) error {
+	if rule.Match == "" {
+		return fmt.Errorf("match is required")
+	}
+	
+	expression, err := regexp.Compile(rule.Match)
+	if err != nil {
+		return fmt.Errorf("invalid match expression: %w", err)
+	}
+	
+	if rule.Path == "" && rule.Query == "" {
+		return fmt.Errorf("path or query is required")
+	}
+	
+	if rule.Path != "" && !strings.HasPrefix(rule.Path, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	
+	captures := make(map[string]bool)
+	for _, name := range expression.SubexpNames() {
+		if name != "" {
+			captures[name] = true
+		}
+	}
+	
+	for _, template := range []string{rule.Path, rule.Query} {
+		for _, match := range templatePlaceholder.FindAllStringSubmatch(template, -1) {
+			if !captures[match[1]] {
+				return fmt.Errorf("template %s refers to no named capture of %s", match[0], rule.Match)
+			}
+		}
+		
+		if strings.ContainsAny(template, "#\r\n") {
+			return fmt.Errorf("%q must not contain # or line breaks", template)
+		}
+	}
+	
+	return nil
+}
+
+// headerTemplatePrefixes are the namespaces available to header templates:
+// the principal, the incoming request and environment variables
+var headerTemplatePrefixes = []string{"user.", "role.", "request.", "env."}
+
+// validateHeaderRules checks header names and template values
+func validateHeaderRules(rules HeaderRules) error {
+	for name, value := range rules.Add {
+		if !validHeaderName(name) {
+			return fmt.Errorf("add: %q is not a valid header name", name)
+		}
+		name = http.CanonicalHeaderKey(name) // Viper lowercases map keys
+		
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("add: value of %s must not contain line breaks", name)
+		}
+		
+		for _, match := range templatePlaceholder.FindAllStringSubmatch(value, -1) {
+			known := false
+			for _, prefix := range headerTemplatePrefixes {
+				known = known || strings.HasPrefix(match[1], prefix)
+			}
+			if !known {
+				return fmt.Errorf("add: unknown template %s in value of %s, expected ${user.*}, ${role.*}, ${request.*} or ${env.*}", match[0], name)
+			}
+			
+			// Secrets such as upstream API keys come from the environment; catch typos at startup
+			if variable, found := strings.CutPrefix(match[1], "env."); found {
+				if _, set := os.LookupEnv(variable); !set {
+					return fmt.Errorf("add: environment variable %s used in value of %s is not set", variable, name)
+				}
+			}
+		}
+	}
+	
+	for _, name := range rules.Remove {
+		if !validHeaderName(name) {
+			return fmt.Errorf("remove: %q is not a valid header name", name)
+		}
+	}
+	
+	for from, to := range rules.Rename {
+		if !validHeaderName(from) || !validHeaderName(to) {
+			return fmt.Errorf("rename: %q -> %q is not a valid header rename", from, to)
+		}
+		
+		if strings.EqualFold(from, "Host") || strings.EqualFold(to, "Host") {
+			return fmt.Errorf("rename: Host can't be renamed, use add or remove")
+		}
+	}
+	
+	return nil
+}
+
+// variantName matches variant names, which appear in metric labels and override values
+var variantName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validateTrafficSplit checks variant names, targets and weights
+func validateTrafficSplit(split TrafficSplit) error {
+	if len(split.Variants) == 0 {
+		if split.Header != "" || split.Cookie != "" {
+			return fmt.Errorf("variants are required when header or cookie is set")
+		}
+		return nil
+	}
+	
+	names := make(map[string]bool, len(split.Variants))
+	users := make(map[string]string)
+	roles := make(map[string]string)
+	totalWeight := 0
+	for i, variant := range split.Variants {
+		if !variantName.MatchString(variant.Name) {
+			return fmt.Errorf("variants[%d].name must consist of letters, digits, '.', '_' or '-'", i)
+		}
+		if names[variant.Name] {
+			return fmt.Errorf("variants[%d].name %s is used twice", i, variant.Name)
+		}
+		names[variant.Name] = true
+		
+		if variant.TargetURL != "" {
+			target, err := url.Parse(variant.TargetURL)
+			if err != nil || target.Scheme == "" || target.Host == "" {
+				return fmt.Errorf("variants[%d].targetUrl %s is not an absolute URL", i, variant.TargetURL)
+			}
+		}
+		
+		if variant.Weight < 0 {
+			return fmt.Errorf("variants[%d].weight must not be negative", i)
+		}
+		totalWeight += variant.Weight
+		
+		// A user or role can only be pinned to one variant
+		for _, user := range variant.Users {
+			if other, found := users[user]; found {
+				return fmt.Errorf("user %s is assigned to both %s and %s", user, other, variant.Name)
+			}
+			users[user] = variant.Name
+		}
+		for _, role := range variant.Roles {
+			if other, found := roles[role]; found {
+				return fmt.Errorf("role %s is assigned to both %s and %s", role, other, variant.Name)
+			}
+			roles[role] = variant.Name
+		}
+	}
+	
+	if totalWeight == 0 {
+		return fmt.Errorf("at least one variant needs a positive weight")
+	}
+	
+	if split.Header != "" && !validHeaderName(split.Header) {
+		return fmt.Errorf("header %q is not a valid header name", split.Header)
+	}
+	if split.Cookie != "" && strings.ContainsAny(split.Cookie, " \t\r\n=;,") {
+		return fmt.Errorf("cookie %q is not a valid cookie name", split.Cookie)
+	}
+	
 	return nil
 }
 
+// validateMirror checks the shadow upstream and the mirroring limits
+func validateMirror(mirror Mirror) error {
+	if mirror.TargetURL == "" {
+		if mirror != (Mirror{}) {
+			return fmt.Errorf("targetUrl is required when mirroring is configured")
+		}
+		return nil
+	}
+	
+	target, err := url.Parse(mirror.TargetURL)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return fmt.Errorf("targetUrl %s is not an absolute URL", mirror.TargetURL)
+	}
+	
+	if mirror.SampleRate <= 0 || mirror.SampleRate > 1 {
+		return fmt.Errorf("sampleRate must be above 0 and at most 1")
+	}
+	
+	if mirror.MaxBodyBytes < 0 || mirror.TimeoutSeconds < 0 || mirror.MaxInFlight < 0 {
+		return fmt.Errorf("maxBodyBytes, timeoutSeconds and maxInFlight must not be negative")
+	}
+	
+	return nil
+}
+
+// validateRouteCache checks the partition and that shared responses can't
+// carry header values of the user or request they were made for
+func validateRouteCache(route Route) error {
+	cache := route.Cache
+	switch cache.Partition {
+	case "", CachePartitionRole, CachePartitionUser:
+	default:
+		return fmt.Errorf("unknown partition %q, expected %s or %s", cache.Partition, CachePartitionRole, CachePartitionUser)
+	}
+	
+	if cache.TTLSeconds < 0 {
+		return fmt.Errorf("ttlSeconds must not be negative")
+	}
+	
+	// Headers with user values would be served to other users holding the same roles
+	if cache.Enabled && route.Protected && cache.Partition != CachePartitionUser {
+		if name := userDependentHeader(route); name != "" {
+			return fmt.Errorf("header %s depends on the user, which needs partition %s", name, CachePartitionUser)
+		}
+	}
+	
+	// Response headers with request values would be served to other requests
+	if cache.Enabled {
+		if name := requestDependentResponseHeader(route); name != "" {
+			return fmt.Errorf("response header %s depends on the request, so responses can't be cached", name)
+		}
+	}
+	
+	return nil
+}
+
+// validateCoalesce checks that shared responses can't carry header values
+// of the user or request they were made for
+func validateCoalesce(route Route) error {
+	if route.Coalesce.MaxBodyBytes < 0 {
+		return fmt.Errorf("maxBodyBytes must not be negative")
+	}
+	
+	if route.Coalesce.Enabled && route.Protected {
+		if name := userDependentHeader(route); name != "" {
+			return fmt.Errorf("header %s depends on the user, so responses can't be shared by users with the same roles", name)
+		}
+	}
+	
+	if route.Coalesce.Enabled {
+		if name := requestDependentResponseHeader(route); name != "" {
+			return fmt.Errorf("response header %s depends on the request, so responses can't be shared", name)
+		}
+	}
+	
+	return nil
+}
+
+// userDependentHeader returns a header the route's rules add with a user
+// value, or "" if there is none
+func userDependentHeader(route Route) string {
+	for _, rules := range []HeaderRules{route.RequestHeaders, route.ResponseHeaders} {
+		for name, value := range rules.Add {
+			if strings.Contains(value, "${user.") {
+				return http.CanonicalHeaderKey(name)
+			}
+		}
+	}
+	return ""
+}
+
+// requestDependentResponseHeader returns a response header the route's rules
+// add with a request value, or "" if there is none
+func requestDependentResponseHeader(route Route) string {
+	for name, value := range route.ResponseHeaders.Add {
+		if strings.Contains(value, "${request.") {
+			return http.CanonicalHeaderKey(name)
+		}
+	}
+	return ""
+}
+
 // LoadRoutes loads routes from a separate configuration file
 func LoadRoutes(routesPath string) ([]Route, error) {
 	v := viper.New()
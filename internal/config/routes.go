@@ -0,0 +1,232 @@
+// Package config handles application configuration
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Matches reports whether the request meets the route's host, method,
+// header and query conditions. The path prefix is not checked.
+func (r *Route) Matches(req *http.Request) bool {
+	if len(r.Hosts) > 0 {
+		host := requestHost(req)
+		matched := false
+		for _, pattern := range r.Hosts {
+			if hostMatches(pattern, host) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	
+	if len(r.Methods) > 0 {
+		matched := false
+		for _, method := range r.Methods {
+			if strings.EqualFold(method, req.Method) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	
+	for _, condition := range r.Headers {
+		values := req.Header.Values(condition.Name)
+		if !conditionMatches(condition, values) {
+			return false
+		}
+	}
+	
+	if len(r.Query) > 0 {
+		query := req.URL.Query()
+		for _, condition := range r.Query {
+			if !conditionMatches(condition, query[condition.Name]) {
+				return false
+			}
+		}
+	}
+	
+	return true
+}
+
+// MatchesPath reports whether the path is under the route's path prefix.
+// The prefix only matches whole segments: "/api" matches "/api" and
+// "/api/v1" but not "/apiv1", while "/api/" only matches paths below it.
+func (r *Route) MatchesPath(path string) bool {
+	if !strings.HasPrefix(path, r.PathPrefix) {
+		return false
+	}
+	return len(path) == len(r.PathPrefix) || strings.HasSuffix(r.PathPrefix, "/") || path[len(r.PathPrefix)] == '/'
+}
+
+// Specificity is the number of conditions the route sets besides the path
+// prefix. Among routes with the same prefix, more specific routes are tried first.
+func (r *Route) Specificity() int {
+	specificity := len(r.Headers) + len(r.Query)
+	if len(r.Hosts) > 0 {
+		specificity++
+	}
+	if len(r.Methods) > 0 {
+		specificity++
+	}
+	return specificity
+}
+
+// requestHost returns the request's host name in lower case, without the port
+func requestHost(req *http.Request) string {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// hostMatches matches a host name against an exact name or a "*." wildcard,
+// which matches any subdomain but not the domain itself
+func hostMatches(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	if suffix, found := strings.CutPrefix(pattern, "*"); found {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return pattern == host
+}
+
+// conditionMatches checks a condition against a header's or parameter's values
+func conditionMatches(condition MatchCondition, values []string) bool {
+	if condition.Value == "" {
+		return len(values) > 0
+	}
+	for _, value := range values {
+		if value == condition.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// routesOverlap reports whether some request could meet the conditions of
+// both routes. The path prefix is not checked.
+func routesOverlap(a, b *Route) bool {
+	if len(a.Hosts) > 0 && len(b.Hosts) > 0 {
+		overlap := false
+		for _, x := range a.Hosts {
+			for _, y := range b.Hosts {
+				overlap = overlap || hostsOverlap(strings.ToLower(x), strings.ToLower(y))
+			}
+		}
+		if !overlap {
+			return false
+		}
+	}
+	
+	if len(a.Methods) > 0 && len(b.Methods) > 0 {
+		overlap := false
+		for _, x := range a.Methods {
+			for _, y := range b.Methods {
+				overlap = overlap || strings.EqualFold(x, y)
+			}
+		}
+		if !overlap {
+			return false
+		}
+	}
+	
+	// Conditions on the same header or parameter with different required values exclude each other
+	for _, x := range a.Headers {
+		for _, y := range b.Headers {
+			if strings.EqualFold(x.Name, y.Name) && x.Value != "" && y.Value != "" && x.Value != y.Value {
+				return false
+			}
+		}
+	}
+	for _, x := range a.Query {
+		for _, y := range b.Query {
+			if x.Name == y.Name && x.Value != "" && y.Value != "" && x.Value != y.Value {
+				return false
+			}
+		}
+	}
+	
+	return true
+}
+
+// ShadowedRoutes describes pairs of routes with the same path prefix whose
+// conditions overlap, where the more specific route takes the requests both
+// match. Such configurations are valid, but easily unintended. A route
+// without conditions is a deliberate fallback and is not reported.
+func ShadowedRoutes(routes []Route) []string {
+	var shadowed []string
+	for i := range routes {
+		for j := 0; j < i; j++ {
+			a, b := &routes[j], &routes[i]
+			if a.PathPrefix != b.PathPrefix || a.Specificity() == b.Specificity() || !routesOverlap(a, b) {
+				continue
+			}
+			winner, loser := j, i
+			if b.Specificity() > a.Specificity() {
+				winner, loser = i, j
+			}
+			if routes[loser].Specificity() == 0 {
+				continue
+			}
+			shadowed = append(shadowed, fmt.Sprintf(
+				"routes[%d] and routes[%d] both match some requests to %s; routes[%d] serves them since it sets more conditions, leaving routes[%d] the rest",
+				j, i, a.PathPrefix, winner, loser))
+		}
+	}
+	return shadowed
+}
+
+// hostsOverlap reports whether two host patterns match a common host name
+func hostsOverlap(x, y string) bool {
+	xSuffix, xWildcard := strings.CutPrefix(x, "*")
+	ySuffix, yWildcard := strings.CutPrefix(y, "*")
+	switch {
+	case xWildcard && yWildcard:
+		return strings.HasSuffix(xSuffix, ySuffix) || strings.HasSuffix(ySuffix, xSuffix)
+	case xWildcard:
+		return hostMatches(x, y)
+	case yWildcard:
+		return hostMatches(y, x)
+	default:
+		return x == y
+	}
+}
+
+// validateRouteConditions checks a route's match conditions
+func validateRouteConditions(route Route) error {
+	for i, host := range route.Hosts {
+		pattern := strings.TrimPrefix(host, "*.")
+		if pattern == "" || strings.ContainsAny(pattern, "*:/ ") {
+			return fmt.Errorf("hosts[%d]: %q must be a host name without port, optionally starting with *.", i, host)
+		}
+	}
+	
+	for i, method := range route.Methods {
+		if method == "" || strings.ContainsAny(method, " \t\r\n") {
+			return fmt.Errorf("methods[%d]: %q is not a valid method", i, method)
+		}
+	}
+	
+	for i, condition := range route.Headers {
+		if !validHeaderName(condition.Name) {
+			return fmt.Errorf("headers[%d]: %q is not a valid header name", i, condition.Name)
+		}
+	}
+	
+	for i, condition := range route.Query {
+		if condition.Name == "" {
+			return fmt.Errorf("query[%d]: name is required", i)
+		}
+	}
+	
+	return nil
+}
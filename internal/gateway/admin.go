@@ -0,0 +1,382 @@
+// Package gateway implements the core API gateway functionality
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"api-gateway/internal/jwtutil"
+	"api-gateway/internal/pocketbase"
+	"api-gateway/internal/responsecache"
+	"api-gateway/internal/revocation"
+)
+
+// adminRouter returns the admin API routes, relative to /admin
+func (g *ApiGateway) adminRouter() chi.Router {
+	r := chi.NewRouter()
+	
+	r.Get("/cache", g.handleAdminCacheStats)
+	r.Post("/cache/refresh", g.handleAdminCacheRefresh)
+	r.Delete("/cache/users/{userID}", g.handleAdminInvalidateUser)
+	r.Delete("/cache/roles/{roleID}", g.handleAdminInvalidateRole)
+	r.Get("/roles/{roleID}/permissions", g.handleAdminRolePermissions)
+	r.Get("/routes", g.handleAdminRoutes)
+	r.Get("/revocations", g.handleAdminListRevocations)
+	r.Post("/revocations", g.handleAdminRevoke)
+	r.Delete("/revocations/{type}/{value}", g.handleAdminUnrevoke)
+	r.Get("/response-cache", g.handleAdminResponseCacheStats)
+	r.Delete("/response-cache", g.handleAdminPurgeResponseCache)
+	
+	return r
+}
+
+// adminMiddleware only lets through users holding the admin role directly
+func (g *ApiGateway) adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := g.authenticate(w, r)
+		if user == nil {
+			return
+		}
+		
+		for _, roleID := range user.AllRoleIDs() {
+			role, err := g.getRole(r.Context(), roleID)
+			if err != nil {
+				if r.Context().Err() != nil {
+					return
+				}
+				g.logger.Error("Failed to get role",
+					zap.Error(err),
+					zap.String("role_id", roleID),
+					zap.String("username", user.Username))
+				g.sendError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+			
+			if role.Name == g.adminRole {
+				ctx := context.WithValue(r.Context(), "user", user)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+		}
+		
+		g.logger.Warn("Admin API access denied",
+			zap.String("username", user.Username),
+			zap.String("path", r.URL.Path))
+		g.metrics.RecordAuthFailure("not_admin")
+		g.sendError(w, http.StatusForbidden, "insufficient permissions")
+	})
+}
+
+// adminActor names who made an admin request, for the audit log
+func adminActor(r *http.Request) zap.Field {
+	if user, ok := r.Context().Value("user").(*pocketbase.User); ok {
+		return zap.String("admin", user.Username)
+	}
+	return zap.String("admin", "admin listener")
+}
+
+// handleAdminCacheStats reports the cache contents and refresh state
+func (g *ApiGateway) handleAdminCacheStats(w http.ResponseWriter, r *http.Request) {
+	g.sendJSON(w, http.StatusOK, g.adminCacheStatus())
+}
+
+// handleAdminCacheRefresh reloads all users and roles from the identity store
+func (g *ApiGateway) handleAdminCacheRefresh(w http.ResponseWriter, r *http.Request) {
+	g.logger.Info("Cache refresh requested through admin API", adminActor(r))
+	
+	if err := g.refreshCache(); err != nil {
+		g.logger.Error("Admin cache refresh failed", zap.Error(err))
+		g.sendError(w, http.StatusBadGateway, "cache refresh failed: "+err.Error())
+		return
+	}
+	
+	g.sendJSON(w, http.StatusOK, g.adminCacheStatus())
+}
+
+// handleAdminInvalidateUser drops all cached tokens of a user
+func (g *ApiGateway) handleAdminInvalidateUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+	removed := g.cache.RemoveUser(userID)
+	
+	g.logger.Info("Invalidated user tokens through admin API",
+		adminActor(r),
+		zap.String("user_id", userID),
+		zap.Int("tokens", removed))
+	
+	g.sendJSON(w, http.StatusOK, map[string]interface{}{
+		"userId":  userID,
+		"removed": removed,
+	})
+}
+
+// handleAdminInvalidateRole drops all cached tokens of users holding a role,
+// including users whose roles inherit from it
+func (g *ApiGateway) handleAdminInvalidateRole(w http.ResponseWriter, r *http.Request) {
+	roleID := chi.URLParam(r, "roleID")
+	removed := g.cache.RemoveRoleUsers(roleID)
+	
+	g.logger.Info("Invalidated role tokens through admin API",
+		adminActor(r),
+		zap.String("role_id", roleID),
+		zap.Int("tokens", removed))
+	
+	g.sendJSON(w, http.StatusOK, map[string]interface{}{
+		"roleId":  roleID,
+		"removed": removed,
+	})
+}
+
+// handleAdminRolePermissions dumps a role's effective permissions as compiled
+// from the role and its ancestors
+func (g *ApiGateway) handleAdminRolePermissions(w http.ResponseWriter, r *http.Request) {
+	roleID := chi.URLParam(r, "roleID")
+	
+	permissions, err := g.cache.EffectivePermissions(roleID)
+	if err != nil {
+		g.sendError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if permissions == nil {
+		g.sendError(w, http.StatusNotFound, "role not found in cache")
+		return
+	}
+	
+	g.sendJSON(w, http.StatusOK, map[string]interface{}{
+		"roleId":             permissions.RoleID,
+		"roleName":           permissions.RoleName,
+		"publish":            permissions.Publish,
+		"subscribe":          permissions.Subscribe,
+		"ancestors":          permissions.Ancestors,
+		"inheritedPublish":   permissions.InheritedPublish,
+		"inheritedSubscribe": permissions.InheritedSubscribe,
+	})
+}
+
+// handleAdminRoutes dumps the configured routes. With a path query
+// parameter, it also shows where a request would be proxied to; its method,
+// host and headers can be given as method, host and "header=Name: value".
+func (g *ApiGateway) handleAdminRoutes(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{
+		"routes": g.routes,
+	}
+	
+	query := r.URL.Query()
+	if path := query.Get("path"); path != "" {
+		method := query.Get("method")
+		if method == "" {
+			method = http.MethodGet
+		}
+		header := make(http.Header)
+		for _, h := range query["header"] {
+			name, value, _ := strings.Cut(h, ":")
+			header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+		
+		resolved, err := g.resolveRoute(strings.ToUpper(method), query.Get("host"), path, header)
+		if err != nil {
+			g.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		response["resolved"] = resolved
+	}
+	
+	g.sendJSON(w, http.StatusOK, response)
+}
+
+// revokeRequest is the body of a revocation request. Exactly one of Token,
+// TokenHash, JTI or UserID must be set.
+type revokeRequest struct {
+	Token     string     `json:"token"`     // Raw token, hashed before it is stored
+	TokenHash string     `json:"tokenHash"` // Token hash as produced by the cache's TokenHasher
+	JTI       string     `json:"jti"`
+	UserID    string     `json:"userId"`
+	Before    *time.Time `json:"before"` // Cut-off for user revocations; defaults to now
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// handleAdminListRevocations lists the active revocations
+func (g *ApiGateway) handleAdminListRevocations(w http.ResponseWriter, r *http.Request) {
+	g.sendJSON(w, http.StatusOK, map[string]interface{}{
+		"revocations": g.revocations.Entries(),
+	})
+}
+
+// handleAdminRevoke adds a revocation, effective immediately
+func (g *ApiGateway) handleAdminRevoke(w http.ResponseWriter, r *http.Request) {
+	var req revokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		g.sendError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	
+	entry := revocation.Entry{
+		Reason:    req.Reason,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: time.Now().UTC(),
+	}
+	set := 0
+	if req.Token != "" {
+		set++
+		entry.Type = revocation.TypeToken
+		entry.Value = g.tokenHasher.HashToken(req.Token)
+		
+		// The entry is no longer needed once the token expires
+		if claims, err := jwtutil.ParseUnverified(req.Token); err == nil && entry.ExpiresAt == nil && claims.ExpiresAt != 0 {
+			expiresAt := claims.Expiry().UTC()
+			entry.ExpiresAt = &expiresAt
+		}
+	}
+	if req.TokenHash != "" {
+		set++
+		entry.Type = revocation.TypeToken
+		entry.Value = strings.ToLower(req.TokenHash)
+	}
+	if req.JTI != "" {
+		set++
+		entry.Type = revocation.TypeJTI
+		entry.Value = req.JTI
+	}
+	if req.UserID != "" {
+		set++
+		entry.Type = revocation.TypeUser
+		entry.Value = req.UserID
+		entry.Before = req.Before
+		if entry.Before == nil {
+			now := time.Now().UTC()
+			entry.Before = &now
+		}
+	}
+	if set != 1 {
+		g.sendError(w, http.StatusBadRequest, "exactly one of token, tokenHash, jti or userId is required")
+		return
+	}
+	if err := entry.Validate(); err != nil {
+		g.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	
+	err := g.revocations.Add(entry)
+	
+	g.logger.Info("Revoked tokens through admin API",
+		adminActor(r),
+		zap.String("type", entry.Type),
+		zap.String("reason", entry.Reason))
+	
+	if err != nil {
+		g.logger.Error("Failed to persist revocation list", zap.Error(err))
+		g.sendError(w, http.StatusInternalServerError, "revocation is active but could not be persisted: "+err.Error())
+		return
+	}
+	
+	g.sendJSON(w, http.StatusCreated, entry)
+}
+
+// handleAdminUnrevoke lifts a revocation
+func (g *ApiGateway) handleAdminUnrevoke(w http.ResponseWriter, r *http.Request) {
+	typ, value := chi.URLParam(r, "type"), chi.URLParam(r, "value")
+	
+	removed, err := g.revocations.Remove(typ, value)
+	if !removed {
+		g.sendError(w, http.StatusNotFound, "revocation not found")
+		return
+	}
+	
+	g.logger.Info("Lifted revocation through admin API",
+		adminActor(r),
+		zap.String("type", typ))
+	
+	if err != nil {
+		g.logger.Error("Failed to persist revocation list", zap.Error(err))
+		g.sendError(w, http.StatusInternalServerError, "revocation was lifted but the change could not be persisted: "+err.Error())
+		return
+	}
+	
+	g.sendJSON(w, http.StatusOK, map[string]interface{}{
+		"type":    typ,
+		"value":   value,
+		"removed": true,
+	})
+}
+
+// handleAdminResponseCacheStats reports the size of the response cache
+func (g *ApiGateway) handleAdminResponseCacheStats(w http.ResponseWriter, r *http.Request) {
+	g.sendJSON(w, http.StatusOK, g.responseCache.Stats())
+}
+
+// handleAdminPurgeResponseCache removes cached responses. Without query
+// parameters, everything is purged; otherwise only responses matching all
+// of route (path prefix of the route), path (prefix of the request path),
+// user (user ID) and role (role ID) are.
+func (g *ApiGateway) handleAdminPurgeResponseCache(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	route, path := query.Get("route"), query.Get("path")
+	userID, roleID := query.Get("user"), query.Get("role")
+	
+	removed := g.responseCache.Purge(func(entry *responsecache.Entry) bool {
+		if route != "" && entry.Route != route {
+			return false
+		}
+		if path != "" && !strings.HasPrefix(entry.Path, path) {
+			return false
+		}
+		if userID != "" && entry.UserID != userID {
+			return false
+		}
+		if roleID != "" && !slices.Contains(entry.RoleIDs, roleID) {
+			return false
+		}
+		return true
+	})
+	
+	stats := g.responseCache.Stats()
+	g.metrics.UpdateResponseCacheSize(stats.Entries, stats.Bytes)
+	
+	g.logger.Info("Purged response cache through admin API",
+		adminActor(r),
+		zap.String("route", route),
+		zap.String("path", path),
+		zap.String("user_id", userID),
+		zap.String("role_id", roleID),
+		zap.Int("removed", removed))
+	
+	g.sendJSON(w, http.StatusOK, map[string]interface{}{
+		"removed": removed,
+		"cache":   stats,
+	})
+}
+
+// adminCacheStatus returns the cache statistics and refresh state
+func (g *ApiGateway) adminCacheStatus() map[string]interface{} {
+	status := map[string]interface{}{
+		"cache":    g.cache.GetStats(),
+		"stale":    g.cache.IsStale(),
+		"degraded": false,
+	}
+	if lastRefresh := g.cache.LastRefresh(); !lastRefresh.IsZero() {
+		status["lastRefresh"] = lastRefresh.Format(time.RFC3339)
+	}
+	if degradedSince := g.degradedAt(); !degradedSince.IsZero() {
+		status["degraded"] = true
+		status["degradedSince"] = degradedSince.Format(time.RFC3339)
+	}
+	return status
+}
+
+// sendJSON sends a JSON response
+func (g *ApiGateway) sendJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	
+	// Keep patterns and URLs readable
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(body)
+}
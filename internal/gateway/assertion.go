@@ -0,0 +1,42 @@
+// Package gateway implements the core API gateway functionality
+package gateway
+
+import (
+	"net/http"
+
+	"api-gateway/internal/assertion"
+	"api-gateway/internal/pocketbase"
+)
+
+// signAssertion mints the identity assertion for the request's user, roles and permissions
+func (g *ApiGateway) signAssertion(req *http.Request, user *pocketbase.User) (string, error) {
+	principal := assertion.Principal{
+		UserID:   user.ID,
+		Username: user.Username,
+	}
+	
+	if roles, ok := req.Context().Value("roles").([]*pocketbase.Role); ok {
+		principal.Roles = make([]assertion.Role, len(roles))
+		for i, role := range roles {
+			principal.Roles[i] = assertion.Role{ID: role.ID, Name: role.Name}
+		}
+	}
+	
+	principal.Permissions.Publish, _ = req.Context().Value("publishPermissions").([]string)
+	principal.Permissions.Subscribe, _ = req.Context().Value("subscribePermissions").([]string)
+	if principal.Permissions.Publish == nil {
+		principal.Permissions.Publish = []string{}
+	}
+	if principal.Permissions.Subscribe == nil {
+		principal.Permissions.Subscribe = []string{}
+	}
+	
+	return g.assertions.Sign(principal)
+}
+
+// handleJWKS publishes the public key upstreams use to verify assertions
+func (g *ApiGateway) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	// Let upstreams cache the key set, but pick up a new key after a restart soon
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	g.sendJSON(w, http.StatusOK, g.assertions.JWKS())
+}
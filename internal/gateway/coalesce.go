@@ -0,0 +1,261 @@
+// Package gateway implements the core API gateway functionality
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/pocketbase"
+	"api-gateway/internal/responsecache"
+)
+
+// Outcomes of coalesced requests, as reported in metrics
+const (
+	coalesceLeader   = "leader"   // Sent upstream on behalf of the others
+	coalesceShared   = "shared"   // Served the leader's response
+	coalesceFallback = "fallback" // Sent upstream itself because the response couldn't be shared
+)
+
+// coalescedHeaders are the request headers that must match, besides the
+// method, path, query and roles, for requests to share a response
+var coalescedHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language"}
+
+// coalescedResponse is a response captured for the requests waiting on it
+type coalescedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+	shared bool // False if the waiting requests must go upstream themselves
+}
+
+// inflightRequest is a request sent upstream that others wait on
+type inflightRequest struct {
+	done     chan struct{} // Closed once response is set
+	response *coalescedResponse
+	waiters  int // Guarded by the coalescer's mutex
+}
+
+// coalescer collapses identical concurrent GET requests of a route
+type coalescer struct {
+	id           string // Distinguishes routes with the same prefix in keys
+	route        string // Path prefix, for logs and metrics
+	protected    bool
+	maxBodyBytes int64
+	credentials  []string // Credential headers, see hasCredentials
+	mutex        sync.Mutex
+	inflight     map[string]*inflightRequest
+}
+
+// newCoalescer creates the coalescer of the route at index in the configuration
+func (g *ApiGateway) newCoalescer(index int, route config.Route) *coalescer {
+	c := &coalescer{
+		id:           fmt.Sprintf("%d:%s", index, route.PathPrefix),
+		route:        route.PathPrefix,
+		protected:    route.Protected,
+		maxBodyBytes: route.Coalesce.MaxBodyBytes,
+		credentials:  g.credentialHeaders,
+		inflight:     make(map[string]*inflightRequest),
+	}
+	if c.maxBodyBytes == 0 {
+		c.maxBodyBytes = config.DefaultCoalesceMaxBodyBytes
+	}
+	
+	g.logger.Info("Coalescing identical route requests",
+		zap.String("pathPrefix", route.PathPrefix),
+		zap.Int64("maxBodyBytes", c.maxBodyBytes))
+	
+	return c
+}
+
+// key identifies requests that can share a response: on split routes only
+// requests assigned to the same variant do. Returns false for requests that
+// can't share one, such as conditional and range requests.
+func (c *coalescer) key(r *http.Request) (string, bool) {
+	if r.Method != http.MethodGet {
+		return "", false
+	}
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range", "Range"} {
+		if r.Header.Get(name) != "" {
+			return "", false
+		}
+	}
+	if responsecache.ParseCacheControl(r.Header.Values("Cache-Control")).Has("no-cache") {
+		return "", false
+	}
+	
+	partition := "public"
+	if c.protected {
+		roleIDs, ok := sortedRoleIDs(r)
+		if !ok {
+			return "", false
+		}
+		partition = "role:" + strings.Join(roleIDs, ",")
+	} else if hasCredentials(r, c.credentials) {
+		// The upstream may answer according to credentials the gateway didn't check
+		return "", false
+	}
+	
+	parts := []string{c.id, partition, requestVariant(r), r.Host, r.URL.Path, r.URL.RawQuery}
+	for _, name := range coalescedHeaders {
+		parts = append(parts, strings.Join(r.Header.Values(name), ", "))
+	}
+	return strings.Join(parts, "\x00"), true
+}
+
+// coalesceHandler lets one of identical concurrent requests through to next
+// and serves its response to the others
+func (g *ApiGateway) coalesceHandler(c *coalescer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := c.key(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		
+		// Wait for an identical request already sent upstream
+		c.mutex.Lock()
+		if leader, found := c.inflight[key]; found {
+			leader.waiters++
+			c.mutex.Unlock()
+			select {
+			case <-leader.done:
+			case <-r.Context().Done():
+				return
+			}
+			g.serveCoalesced(c, w, r, leader.response, next)
+			return
+		}
+		
+		// Otherwise lead: stream the response to this client while capturing it
+		request := &inflightRequest{done: make(chan struct{})}
+		c.inflight[key] = request
+		c.mutex.Unlock()
+		
+		writer := &captureWriter{ResponseWriter: w, maxBytes: c.maxBodyBytes}
+		defer func() {
+			// If the handler panicked, the waiting requests go upstream themselves
+			if request.response == nil {
+				request.response = &coalescedResponse{}
+			}
+			c.mutex.Lock()
+			delete(c.inflight, key)
+			waiters := request.waiters
+			c.mutex.Unlock()
+			close(request.done)
+			
+			if waiters > 0 {
+				g.metrics.RecordCoalescedRequest(c.route, coalesceLeader)
+			}
+		}()
+		
+		next.ServeHTTP(writer, r)
+		request.response = writer.response(r)
+	})
+}
+
+// serveCoalesced serves a request the leader's response, or passes it to
+// next if the response can't be shared
+func (g *ApiGateway) serveCoalesced(c *coalescer, w http.ResponseWriter, r *http.Request, response *coalescedResponse, next http.Handler) {
+	// Responses that can't be shared are fetched separately
+	if !response.shared {
+		g.metrics.RecordCoalescedRequest(c.route, coalesceFallback)
+		next.ServeHTTP(w, r)
+		return
+	}
+	
+	g.metrics.RecordCoalescedRequest(c.route, coalesceShared)
+	header := w.Header()
+	for name, values := range response.header {
+		header[name] = append([]string(nil), values...)
+	}
+	w.WriteHeader(response.status)
+	w.Write(response.body)
+}
+
+// sortedRoleIDs returns the IDs of all roles of the authenticated user,
+// sorted, since permissions are merged from all of them. Returns false if
+// there is no authenticated user.
+func sortedRoleIDs(r *http.Request) ([]string, bool) {
+	if _, ok := r.Context().Value("user").(*pocketbase.User); !ok {
+		return nil, false
+	}
+	
+	roles, _ := r.Context().Value("roles").([]*pocketbase.Role)
+	roleIDs := make([]string, len(roles))
+	for i, role := range roles {
+		roleIDs[i] = role.ID
+	}
+	sort.Strings(roleIDs)
+	return roleIDs, true
+}
+
+// captureWriter passes a response to the client while keeping a copy for
+// requests waiting on it
+type captureWriter struct {
+	http.ResponseWriter
+	maxBytes int64
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *captureWriter) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		w.status = status
+		w.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	
+	// Stop copying once the response is too large to share
+	if !w.overflow {
+		if int64(w.body.Len()+len(p)) > w.maxBytes {
+			w.overflow = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(p)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush keeps streamed responses flowing
+func (w *captureWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the client's writer
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// response returns the captured response. It is only shared if it was
+// complete, fit within the size limit and belongs to no single user.
+func (w *captureWriter) response(r *http.Request) *coalescedResponse {
+	response := &coalescedResponse{
+		status: w.status,
+		header: w.header,
+		body:   w.body.Bytes(),
+		shared: w.status != 0 && !w.overflow && r.Context().Err() == nil,
+	}
+	if response.shared {
+		directives := responsecache.ParseCacheControl(w.header.Values("Cache-Control"))
+		response.shared = w.header.Get("Set-Cookie") == "" && !directives.Has("private") && !directives.Has("no-store")
+	}
+	return response
+}
@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCoalesceSkipsRequestsWithCookies(t *testing.T) {
+	arrived := make(chan string, 2)
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := r.Header.Get("Cookie")
+		arrived <- session
+		<-release
+		w.Write([]byte(session))
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	g := newTestGateway(t, `{
+		"store": {"type": "file", "file": {"path": "$STORE"}},
+		"routes": [{"pathPrefix": "/pub/", "targetUrl": "$UPSTREAM", "protected": false, "coalesce": {"enabled": true}}]
+	}`, upstream)
+
+	bodies := make(chan string, 2)
+	get := func(cookie string) {
+		req := httptest.NewRequest(http.MethodGet, "/pub/profile", nil)
+		req.Header.Set("Cookie", cookie)
+		bodies <- serve(g, req).Body.String()
+	}
+
+	// The second request must reach the upstream while the first is in flight
+	go get("session=alice")
+	if got := receive(t, arrived, "first request upstream"); got != "session=alice" {
+		t.Fatalf("upstream got cookie %q", got)
+	}
+	go get("session=bob")
+	select {
+	case got := <-arrived:
+		if got != "session=bob" {
+			t.Fatalf("upstream got cookie %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("request with another cookie was coalesced with the first")
+	}
+
+	release <- struct{}{}
+	release <- struct{}{}
+	got := map[string]bool{<-bodies: true, <-bodies: true}
+	if !got["session=alice"] || !got["session=bob"] {
+		t.Errorf("clients got responses %v", got)
+	}
+}
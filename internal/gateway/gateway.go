@@ -4,22 +4,36 @@ package gateway
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 
+	"api-gateway/internal/assertion"
 	"api-gateway/internal/cache"
 	"api-gateway/internal/config"
+	"api-gateway/internal/jwtutil"
 	"api-gateway/internal/metrics"
+	"api-gateway/internal/pathnorm"
 	"api-gateway/internal/pocketbase"
+	"api-gateway/internal/responsecache"
+	"api-gateway/internal/revocation"
+	"api-gateway/internal/snapshot"
+	"api-gateway/internal/store"
 	"api-gateway/pkg/permissions"
 )
 
@@ -27,12 +41,67 @@ import (
 type ApiGateway struct {
 	router       *chi.Mux
 	logger       *zap.Logger
-	pbClient     *pocketbase.Client
+	pbClient     *pocketbase.Client // Nil unless PocketBase is the identity store
+	store        store.UserRoleStore
+	connectStore func(context.Context) error // Nil if the store needs no connection
 	cache        *cache.Cache
 	metrics      *metrics.Metrics
 	routes       []config.Route
+	proxyRoutes  []*proxyRoute // Sorted by matching precedence
 	cacheTTL     time.Duration
 	permMatcher  *permissions.Matcher
+	pathOptions  pathnorm.Options
+	
+	// Extra user fields, forwarded upstream when a header is configured
+	extraUserFields []config.ExtraUserField
+	
+	// Headers stripped from every incoming request
+	reservedHeaders []string
+	
+	// Headers carrying client credentials, which keep responses on
+	// unprotected routes from being shared
+	credentialHeaders []string
+	
+	// Signs the identity assertion forwarded upstream; nil if disabled
+	assertions      *assertion.Signer
+	assertionHeader string
+	
+	// Revoked tokens, checked before the cache
+	revocations *revocation.List
+	
+	// Responses of caching routes, shared by all routes
+	responseCache     *responsecache.Store
+	maxCachedResponse int64 // Larger responses are not cached
+	
+	// Request size limits of routes that set none
+	limits config.RequestLimits
+	
+	// Largest request size limits of any route, checked before routing
+	maxLimits config.RequestLimits
+	
+	// Admin API, gated by a role on the main listener or served on its own
+	adminRole    string
+	adminHandler http.Handler // Non-nil if the admin API has a separate listener
+	
+	// Background cache refresh state
+	refreshMutex  sync.Mutex
+	refreshJitter float64
+	refreshRetry  time.Duration
+	
+	// Degraded mode: serving from a snapshot until the identity store is reachable
+	snapshots      *snapshot.Store // Nil if snapshots are disabled
+	snapshotMaxAge time.Duration
+	storeConnected atomic.Bool
+	degradedSince  atomic.Int64 // Unix nanoseconds; zero when not degraded
+	
+	// Lifecycle of background goroutines (refresher, realtime subscription)
+	background     context.Context
+	stopBackground context.CancelFunc
+	backgroundWG   sync.WaitGroup
+	
+	// In-flight token validations, keyed by hashed token
+	tokenValidations singleflight.Group
+	tokenHasher      *cache.TokenHasher
 }
 
 // New creates a new API gateway
@@ -40,39 +109,111 @@ func New(cfg *config.Config, logger *zap.Logger) (*ApiGateway, error) {
 	// Initialize the metrics
 	m := metrics.NewMetrics("api_gateway")
 	
-	// Initialize the PocketBase client
-	pbClient := pocketbase.NewClient(
-		cfg.PocketBase.URL,
-		cfg.PocketBase.UserCollection,
-		cfg.PocketBase.RoleCollection,
-		logger.With(zap.String("component", "pocketbase")),
-	)
+	// Initialize the identity store
+	userStore, pbClient, connectStore, err := newStore(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Initialize the snapshot store
+	var snapshots *snapshot.Store
+	if cfg.Snapshot.Path != "" {
+		var key []byte
+		if cfg.Snapshot.EncryptionKey != "" {
+			key, err = config.DecodeSnapshotKey(cfg.Snapshot.EncryptionKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+		snapshots, err = snapshot.NewStore(cfg.Snapshot.Path, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize snapshots: %w", err)
+		}
+	}
 	
-	// Authenticate with PocketBase
-	if err := pbClient.Authenticate(cfg.PocketBase.ServiceAccount, cfg.PocketBase.ServicePassword); err != nil {
-		return nil, fmt.Errorf("failed to authenticate with PocketBase: %w", err)
+	// Load the revocation list
+	revocations, err := revocation.New(cfg.Revocation.Path)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Initialize the identity assertion signer
+	var assertions *assertion.Signer
+	if cfg.Assertion.Enabled {
+		assertions, err = assertion.NewSigner(assertion.Options{
+			Algorithm:      cfg.Assertion.Algorithm,
+			PrivateKeyFile: cfg.Assertion.PrivateKeyFile,
+			Issuer:         cfg.Assertion.Issuer,
+			Audience:       cfg.Assertion.Audience,
+			TTL:            time.Duration(cfg.Assertion.TTLSeconds) * time.Second,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize identity assertions: %w", err)
+		}
+		if cfg.Assertion.PrivateKeyFile == "" {
+			logger.Warn("No assertion signing key configured, using a key generated at startup; upstreams must refetch the JWKS after restarts")
+		}
+		logger.Info("Signing identity assertions",
+			zap.String("algorithm", cfg.Assertion.Algorithm),
+			zap.String("kid", assertions.KeyID()),
+			zap.String("header", cfg.Assertion.Header))
 	}
 	
 	// Initialize the cache
 	cacheComponent := cache.New(
 		time.Duration(cfg.CacheTTLSeconds)*time.Second,
+		cache.Options{
+			MaxTokenTTL:       time.Duration(cfg.Cache.MaxTokenTTLSeconds) * time.Second,
+			MaxTokens:         cfg.Cache.MaxTokens,
+			NegativeTTL:       time.Duration(cfg.Cache.NegativeTTLSeconds) * time.Second,
+			MaxRejectedTokens: cfg.Cache.MaxRejectedTokens,
+		},
 		logger.With(zap.String("component", "cache")),
 	)
 	
 	// Initialize the permission matcher
 	permMatcher := permissions.NewMatcher()
 	
+	// Resolve the path normalization policy (already validated at config load)
+	pathPolicy, err := pathnorm.ParsePolicy(cfg.PathNormalization.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path normalization policy: %w", err)
+	}
+	
 	// Create the gateway
 	gw := &ApiGateway{
 		router:       chi.NewRouter(),
 		logger:       logger,
 		pbClient:     pbClient,
+		store:        userStore,
+		connectStore: connectStore,
 		cache:        cacheComponent,
 		metrics:      m,
 		routes:       cfg.Routes,
 		cacheTTL:     time.Duration(cfg.CacheTTLSeconds) * time.Second,
 		permMatcher:  permMatcher,
+		pathOptions: pathnorm.Options{
+			Policy:               pathPolicy,
+			DecodeEncodedSlashes: cfg.PathNormalization.DecodeEncodedSlashes,
+		},
+		extraUserFields: cfg.PocketBase.FieldMapping.User.Extra,
+		reservedHeaders: collectReservedHeaders(cfg),
+		credentialHeaders: collectCredentialHeaders(cfg),
+		refreshJitter: float64(cfg.Cache.RefreshJitterPercent) / 100,
+		refreshRetry:  time.Duration(cfg.Cache.RefreshRetrySeconds) * time.Second,
+		tokenHasher:   cache.NewTokenHasher(),
+		snapshots:      snapshots,
+		snapshotMaxAge: time.Duration(cfg.Snapshot.MaxAgeSeconds) * time.Second,
+		revocations:    revocations,
+		assertions:      assertions,
+		assertionHeader: cfg.Assertion.Header,
+		adminRole:      cfg.Admin.Role,
+		responseCache:     responsecache.New(cfg.ResponseCache.MaxBytes),
+		maxCachedResponse: cfg.ResponseCache.MaxEntryBytes,
+		limits:            cfg.Limits,
+		maxLimits:         cfg.MaxRequestLimits(),
 	}
+	gw.background, gw.stopBackground = context.WithCancel(context.Background())
 	
 	// Set up router middleware
 	gw.router.Use(middleware.RequestID)
@@ -81,157 +222,397 @@ func New(cfg *config.Config, logger *zap.Logger) (*ApiGateway, error) {
 	gw.router.Use(middleware.Recoverer)
 	gw.router.Use(middleware.Timeout(30 * time.Second))
 	gw.router.Use(gw.metricsMiddleware)
+	gw.router.Use(gw.limitsMiddleware)
+	gw.router.Use(gw.canonicalPathMiddleware)
+	gw.router.Use(gw.routeLimitsMiddleware)
+	gw.router.Use(gw.stripReservedHeaders)
 	
 	// Set up routes
 	gw.router.Get("/health", gw.handleHealth)
 	gw.router.Handle("/metrics", promhttp.Handler())
+	if assertions != nil {
+		gw.router.Get("/.well-known/jwks.json", gw.handleJWKS)
+	}
+	
+	// Set up the admin API, either behind the admin role or on its own listener
+	if cfg.Admin.Enabled {
+		if cfg.Admin.ListenAddress == "" {
+			gw.router.With(gw.adminMiddleware).Mount("/admin", gw.adminRouter())
+		} else {
+			adminRouter := chi.NewRouter()
+			adminRouter.Use(middleware.RequestID)
+			adminRouter.Use(middleware.RealIP)
+			adminRouter.Use(gw.loggingMiddleware)
+			adminRouter.Use(middleware.Recoverer)
+			adminRouter.Use(middleware.Timeout(30 * time.Second))
+			adminRouter.Use(gw.globalLimitsMiddleware)
+			adminRouter.Mount("/admin", gw.adminRouter())
+			gw.adminHandler = adminRouter
+		}
+	}
 	
 	// Set up proxy routes
 	if err := gw.setupProxyRoutes(); err != nil {
 		return nil, fmt.Errorf("failed to set up proxy routes: %w", err)
 	}
 	
-	// Preload cache
-	if err := gw.refreshCache(); err != nil {
-		logger.Warn("Failed to preload cache, will retry on first request", zap.Error(err))
+	// Connect to the identity store and preload the cache. If the store
+	// is unavailable, start from the last snapshot in degraded mode.
+	if err := gw.connect(); err != nil {
+		if !gw.startDegraded(err) {
+			gw.Close()
+			return nil, err
+		}
+	} else if err := gw.refreshCache(); err != nil {
+		if !gw.startDegraded(err) {
+			logger.Warn("Failed to preload cache, will retry in the background", zap.Error(err))
+		}
+	}
+	
+	// Keep the cache fresh off the request path
+	gw.backgroundWG.Add(1)
+	go gw.runCacheRefresher()
+	
+	// Apply PocketBase changes as they happen; polling remains the fallback
+	if pbClient != nil && cfg.PocketBase.Realtime.Enabled {
+		gw.backgroundWG.Add(1)
+		go gw.runRealtimeSubscription(
+			time.Duration(cfg.PocketBase.Realtime.MinBackoffSeconds)*time.Second,
+			time.Duration(cfg.PocketBase.Realtime.MaxBackoffSeconds)*time.Second,
+		)
 	}
 	
 	return gw, nil
 }
 
+// Close stops background work started by New. It is safe to call more than once.
+func (g *ApiGateway) Close() {
+	g.stopBackground()
+	g.backgroundWG.Wait()
+	
+	// Release the store once nothing uses it anymore
+	if closer, ok := g.store.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
 // ServeHTTP implements the http.Handler interface
 func (g *ApiGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	g.router.ServeHTTP(w, r)
 }
 
-// refreshCache refreshes the user and role caches from PocketBase
+// AdminHandler returns the admin API handler to serve on the separate admin
+// listener, or nil if the admin API is disabled or shares the main listener
+func (g *ApiGateway) AdminHandler() http.Handler {
+	return g.adminHandler
+}
+
+// refreshCache loads a new user and role snapshot from the identity store.
+// On failure the previous snapshot stays in place and keeps being served.
 func (g *ApiGateway) refreshCache() error {
-	// Check if refresh is needed
-	if !g.cache.RefreshIfNeeded() {
-		return nil
-	}
+	// Serialize refreshes so a slow one is never overlapped by the next
+	g.refreshMutex.Lock()
+	defer g.refreshMutex.Unlock()
 	
-	g.logger.Debug("Refreshing cache from PocketBase")
+	g.logger.Debug("Refreshing cache from identity store")
+	
+	// Connect first if the store was unavailable at startup
+	if !g.storeConnected.Load() {
+		if err := g.connect(); err != nil {
+			g.metrics.RecordCacheRefreshFailure()
+			return err
+		}
+	}
 	
 	// Get all roles
-	roles, err := g.pbClient.GetAllRoles()
+	roles, err := g.store.GetAllRolesContext(g.background)
 	if err != nil {
+		g.metrics.RecordCacheRefreshFailure()
 		return fmt.Errorf("failed to get roles: %w", err)
 	}
 	
-	// Get all users
-	users, err := g.pbClient.GetAllUsers()
+	// Stream all users page by page, keeping only the records of users
+	// that have cached tokens so memory stays bounded by the token cache
+	tracked := g.cache.TrackedUserIDs()
+	activeUsers := make(map[string]*pocketbase.User, len(tracked))
+	err = g.store.ForEachUserContext(g.background, func(user pocketbase.User) error {
+		if user.Active && tracked[user.ID] {
+			activeUsers[user.ID] = &user
+		}
+		return nil
+	})
 	if err != nil {
+		g.metrics.RecordCacheRefreshFailure()
 		return fmt.Errorf("failed to get users: %w", err)
 	}
 	
-	// Update the cache
-	g.cache.BulkLoadRoles(roles)
-	g.cache.BulkLoadUsers(users)
+	// Update the cache; hierarchy problems are reported but do not fail the refresh
+	if err := g.cache.BulkLoadRoles(roles); err != nil {
+		g.logger.Error("Problems detected in role hierarchy", zap.Error(err))
+	}
+	g.cache.ReconcileUsers(tracked, activeUsers)
+	g.cache.MarkRefreshed()
 	
 	// Update metrics
 	stats := g.cache.GetStats()
 	g.metrics.UpdateCacheSize(stats["users"], stats["roles"])
 	g.metrics.RecordCacheRefresh()
+	g.metrics.SetCacheLastRefresh(g.cache.LastRefresh())
+	g.metrics.SetCacheStaleness(0)
 	
 	g.logger.Info("Cache refreshed", 
 		zap.Int("users", stats["users"]), 
 		zap.Int("roles", stats["roles"]))
 	
+	// Live data is in place; leave degraded mode and remember it as the last known good state
+	g.leaveDegraded()
+	g.saveSnapshot()
+	
 	return nil
 }
 
-// authMiddleware authenticates and authorizes requests
-func (g *ApiGateway) authMiddleware(next http.Handler) http.Handler {
+// runCacheRefresher refreshes the cache in the background every cache TTL,
+// with jitter so that gateway replicas don't hit PocketBase in lockstep.
+// Failed refreshes are retried sooner while the last good snapshot is served.
+func (g *ApiGateway) runCacheRefresher() {
+	defer g.backgroundWG.Done()
+	
+	// Pick the first delay based on whether the preload succeeded
+	delay := g.jitter(g.cacheTTL)
+	if g.cache.LastRefresh().IsZero() {
+		delay = g.jitter(g.refreshRetry)
+	}
+	
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	
+	// Staleness grows continuously, so sample it independently of refreshes
+	stalenessTicker := time.NewTicker(5 * time.Second)
+	defer stalenessTicker.Stop()
+	
+	for {
+		select {
+		case <-g.background.Done():
+			return
+			
+		case <-stalenessTicker.C:
+			g.updateStaleness()
+			
+		case <-timer.C:
+			if err := g.refreshCache(); err != nil {
+				g.logger.Error("Background cache refresh failed, serving last good snapshot",
+					zap.Error(err),
+					zap.Time("last_refresh", g.cache.LastRefresh()))
+				timer.Reset(g.jitter(g.refreshRetry))
+				continue
+			}
+			timer.Reset(g.jitter(g.cacheTTL))
+		}
+	}
+}
+
+// updateStaleness publishes the age of the cached snapshot
+func (g *ApiGateway) updateStaleness() {
+	lastRefresh := g.cache.LastRefresh()
+	if lastRefresh.IsZero() {
+		return
+	}
+	g.metrics.SetCacheStaleness(time.Since(lastRefresh))
+}
+
+// jitter spreads an interval randomly by the configured jitter fraction
+func (g *ApiGateway) jitter(d time.Duration) time.Duration {
+	if g.refreshJitter <= 0 {
+		return d
+	}
+	spread := (rand.Float64()*2 - 1) * g.refreshJitter
+	return time.Duration(float64(d) * (1 + spread))
+}
+
+// canonicalPathMiddleware canonicalizes the request path before routing,
+// permission checks and proxying, so that all of them see the same path.
+// Paths that cannot be canonicalized under the configured policy are rejected.
+func (g *ApiGateway) canonicalPathMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get start time for metrics
-		startTime := time.Now()
-		
-		// Extract token from Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			g.metrics.RecordAuthFailure("missing_token")
-			g.sendError(w, http.StatusUnauthorized, "missing authorization token")
+		canonical, err := pathnorm.Canonicalize(r.URL, g.pathOptions)
+		if err != nil {
+			reason := "invalid"
+			var pathErr *pathnorm.Error
+			if errors.As(err, &pathErr) {
+				reason = pathErr.Reason
+			}
+			
+			g.logger.Debug("Rejected ambiguous request path",
+				zap.String("raw_path", r.URL.EscapedPath()),
+				zap.String("reason", reason),
+				zap.String("policy", g.pathOptions.Policy.String()))
+			
+			g.metrics.RecordPathRejection(reason)
+			g.sendError(w, http.StatusBadRequest, "ambiguous request path")
 			return
 		}
 		
-		// Format should be "Bearer {token}"
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			g.metrics.RecordAuthFailure("invalid_token_format")
-			g.sendError(w, http.StatusUnauthorized, "invalid authorization format")
+		// Nothing to do if the path is already canonical
+		if canonical == r.URL.Path && r.URL.RawPath == "" {
+			next.ServeHTTP(w, r)
 			return
 		}
 		
-		token := parts[1]
+		g.logger.Debug("Normalized request path",
+			zap.String("raw_path", r.URL.EscapedPath()),
+			zap.String("canonical_path", canonical))
 		
-		// Refresh cache if needed
-		if err := g.refreshCache(); err != nil {
-			g.logger.Error("Failed to refresh cache", zap.Error(err))
-			g.sendError(w, http.StatusInternalServerError, "internal server error")
-			return
+		// Shallow copy the request with a rewritten URL, as http.StripPrefix does.
+		// Clearing RawPath makes the escaped form derive from the canonical path.
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = canonical
+		r2.URL.RawPath = ""
+		
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// authenticate resolves the request's bearer token to a user. It sends the
+// error response and returns nil if the request can't be authenticated.
+func (g *ApiGateway) authenticate(w http.ResponseWriter, r *http.Request) *pocketbase.User {
+	// Extract token from Authorization header
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		g.metrics.RecordAuthFailure("missing_token")
+		g.sendError(w, http.StatusUnauthorized, "missing authorization token")
+		return nil
+	}
+	
+	// Format should be "Bearer {token}"
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		g.metrics.RecordAuthFailure("invalid_token_format")
+		g.sendError(w, http.StatusUnauthorized, "invalid authorization format")
+		return nil
+	}
+	
+	token := parts[1]
+	
+	// Reject revoked tokens before anything else, including cached ones
+	claims, _ := jwtutil.ParseUnverified(token) // Nil if not a JWT, e.g. an API key
+	if revoked, kind := g.revocations.IsRevoked(g.tokenHasher.HashToken(token), claims); revoked {
+		g.logger.Debug("Rejected revoked token", zap.String("revocation", kind))
+		g.metrics.RecordAuthFailure("revoked_token")
+		g.sendError(w, http.StatusUnauthorized, "invalid or expired token")
+		return nil
+	}
+	
+	// Try to get user from cache by token (now using complete token with secure hashing)
+	user := g.cache.GetUserByToken(token)
+	if user != nil {
+		g.metrics.RecordTokenLookup("hit")
+	} else if g.cache.IsTokenRejected(token) {
+		// Token was recently rejected, don't ask PocketBase again
+		g.metrics.RecordTokenLookup("negative_hit")
+		g.metrics.RecordAuthFailure("invalid_token")
+		g.sendError(w, http.StatusUnauthorized, "invalid or expired token")
+		return nil
+	} else {
+		// User not in cache, validate token with PocketBase
+		fetchedUser, err := g.validateToken(r.Context(), token)
+		if err != nil {
+			// The client went away or the request deadline passed;
+			// the timeout middleware answers the latter
+			if r.Context().Err() != nil {
+				g.logger.Debug("Request ended during token validation", zap.Error(err))
+				g.metrics.RecordAuthFailure("cancelled")
+				return nil
+			}
+			g.logger.Debug("Token validation failed", 
+				zap.Error(err))
+			g.metrics.RecordAuthFailure("invalid_token")
+			g.sendError(w, http.StatusUnauthorized, "invalid or expired token")
+			return nil
 		}
+		user = fetchedUser
+	}
+	
+	// User revocations apply to the user the store resolved the token to,
+	// whether it came from the cache or was just validated
+	var issuedAt time.Time
+	if claims != nil {
+		issuedAt = claims.IssuedAtTime()
+	}
+	if g.revocations.IsUserRevoked(user.ID, issuedAt) {
+		g.logger.Debug("Rejected token of revoked user",
+			zap.String("user_id", user.ID),
+			zap.String("revocation", revocation.TypeUser))
+		g.metrics.RecordAuthFailure("revoked_token")
+		g.sendError(w, http.StatusUnauthorized, "invalid or expired token")
+		return nil
+	}
+	
+	return user
+}
+
+// authMiddleware authenticates and authorizes requests
+func (g *ApiGateway) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Get start time for metrics
+		startTime := time.Now()
 		
-		// Try to get user from cache by token (now using complete token with secure hashing)
-		user := g.cache.GetUserByToken(token)
+		user := g.authenticate(w, r)
 		if user == nil {
-			// User not in cache, validate token with PocketBase
-			fetchedUser, err := g.pbClient.GetUserByToken(token)
-			if err != nil {
-				g.logger.Debug("Token validation failed", 
-					zap.Error(err))
-				g.metrics.RecordAuthFailure("invalid_token")
-				g.sendError(w, http.StatusUnauthorized, "invalid or expired token")
-				return
-			}
-			
-			// Add user to cache with the full token (which will be securely hashed)
-			user = fetchedUser
-			g.cache.AddUser(token, user)
+			return
 		}
 		
 		// No need to check if user is active - already checked in GetUserByToken
 		
-		// Get role from cache
-		role := g.cache.GetRoleByID(user.RoleID)
-		if role == nil {
-			// Role not in cache, try to get from PocketBase
-			fetchedRole, err := g.pbClient.GetRoleByID(user.RoleID)
+		// Resolve every role assigned to the user
+		roleIDs := user.AllRoleIDs()
+		if len(roleIDs) == 0 {
+			g.logger.Debug("User has no roles assigned", zap.String("username", user.Username))
+			g.metrics.RecordAuthFailure("no_role")
+			g.sendError(w, http.StatusForbidden, "insufficient permissions")
+			return
+		}
+		
+		roles := make([]*pocketbase.Role, 0, len(roleIDs))
+		effective := make([]*cache.EffectivePermissions, 0, len(roleIDs))
+		for _, roleID := range roleIDs {
+			role, err := g.getRoleHierarchy(r.Context(), roleID)
 			if err != nil {
+				if r.Context().Err() != nil {
+					g.logger.Debug("Request ended during role lookup", zap.Error(err))
+					g.metrics.RecordAuthFailure("cancelled")
+					return
+				}
 				g.logger.Error("Failed to get role", 
 					zap.Error(err), 
-					zap.String("role_id", user.RoleID),
+					zap.String("role_id", roleID),
 					zap.String("username", user.Username))
 				g.metrics.RecordAuthFailure("role_not_found")
 				g.sendError(w, http.StatusInternalServerError, "internal server error")
 				return
 			}
+			roles = append(roles, role)
 			
-			// Add role to cache
-			role = fetchedRole
-			g.cache.AddRole(role.ID, role)
-		}
-		
-		// Get role permissions
-		publishPermissions, err := role.GetPublishPermissions()
-		if err != nil {
-			g.logger.Error("Failed to parse publish permissions", 
-				zap.Error(err), 
-				zap.String("role", role.Name))
-			g.metrics.RecordAuthFailure("invalid_permissions")
-			g.sendError(w, http.StatusInternalServerError, "internal server error")
-			return
+			// Resolve permissions including those inherited from ancestors
+			permissions, err := g.cache.EffectivePermissions(roleID)
+			if err != nil || permissions == nil {
+				g.logger.Error("Failed to resolve role permissions", 
+					zap.Error(err), 
+					zap.String("role", role.Name))
+				g.metrics.RecordAuthFailure("invalid_permissions")
+				g.sendError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+			effective = append(effective, permissions)
 		}
 		
-		subscribePermissions, err := role.GetSubscribePermissions()
-		if err != nil {
-			g.logger.Error("Failed to parse subscribe permissions", 
-				zap.Error(err), 
-				zap.String("role", role.Name))
-			g.metrics.RecordAuthFailure("invalid_permissions")
-			g.sendError(w, http.StatusInternalServerError, "internal server error")
-			return
-		}
+		// Merge permissions across all roles and fill in ${user.*} templates
+		publishPermissions, subscribePermissions := mergeRolePermissions(effective)
+		vars := templateVars(user)
+		publishPermissions = g.permMatcher.ExpandTemplates(publishPermissions, vars)
+		subscribePermissions = g.permMatcher.ExpandTemplates(subscribePermissions, vars)
 		
 		// Extract the top-level prefix from the path for better debug logging
 		pathParts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
@@ -247,7 +628,8 @@ func (g *ApiGateway) authMiddleware(next http.Handler) http.Handler {
 				zap.String("method", r.Method),
 				zap.String("top_level_prefix", topLevelPrefix),
 				zap.Strings("publish_permissions", publishPermissions),
-				zap.Strings("subscribe_permissions", subscribePermissions))
+				zap.Strings("subscribe_permissions", subscribePermissions),
+				zap.Any("inherited_permissions", explainInheritance(effective)))
 				
 			g.metrics.RecordAuthFailure("insufficient_permissions")
 			g.sendError(w, http.StatusForbidden, "insufficient permissions")
@@ -258,11 +640,15 @@ func (g *ApiGateway) authMiddleware(next http.Handler) http.Handler {
 			zap.String("path", r.URL.Path),
 			zap.String("method", r.Method),
 			zap.String("top_level_prefix", topLevelPrefix),
-			zap.String("username", user.Username))
+			zap.String("username", user.Username),
+			zap.Any("inherited_permissions", explainInheritance(effective)))
 		
-		// Add user and role to request context
+		// Add user and roles to request context; "role" holds the primary role
 		ctx := context.WithValue(r.Context(), "user", user)
-		ctx = context.WithValue(ctx, "role", role)
+		ctx = context.WithValue(ctx, "role", roles[0])
+		ctx = context.WithValue(ctx, "roles", roles)
+		ctx = context.WithValue(ctx, "publishPermissions", publishPermissions)
+		ctx = context.WithValue(ctx, "subscribePermissions", subscribePermissions)
 		
 		// Record request duration for auth processing
 		g.metrics.ObserveRequestDuration(r.Method, "auth_processing", time.Since(startTime).Seconds())
@@ -272,14 +658,301 @@ func (g *ApiGateway) authMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// templateVars returns the values available to permission templates for a user
+func templateVars(user *pocketbase.User) map[string]string {
+	vars := make(map[string]string, len(user.Extra)+3)
+	for field, value := range user.Extra {
+		vars["user."+field] = value
+	}
+	
+	// Built-in attributes take precedence over extra fields of the same name
+	vars["user.id"] = user.ID
+	vars["user.username"] = user.Username
+	vars["user.email"] = user.Email
+	return vars
+}
+
+// validateToken validates a token with PocketBase and caches the outcome.
+// Concurrent validations of the same token are coalesced into a single call,
+// and definitive rejections are remembered in the negative cache.
+// A caller whose ctx ends stops waiting, but the shared validation keeps
+// running for the other callers, bounded by the first caller's deadline.
+func (g *ApiGateway) validateToken(ctx context.Context, token string) (*pocketbase.User, error) {
+	executed := false
+	results := g.tokenValidations.DoChan(g.tokenHasher.HashToken(token), func() (interface{}, error) {
+		executed = true
+		
+		validationCtx := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			validationCtx, cancel = context.WithDeadline(validationCtx, deadline)
+			defer cancel()
+		}
+		
+		user, err := g.store.GetUserByTokenContext(validationCtx, token)
+		if err != nil {
+			if errors.Is(err, store.ErrInvalidToken) {
+				g.cache.AddRejectedToken(token)
+			}
+			return nil, err
+		}
+		
+		// Roles fetched along with the user save a lookup each
+		for i := range user.ExpandedRoles {
+			if g.cache.GetRoleByID(user.ExpandedRoles[i].ID) == nil {
+				g.cache.AddRole(user.ExpandedRoles[i].ID, &user.ExpandedRoles[i])
+			}
+		}
+		
+		// Add user to cache with the full token (which will be securely hashed)
+		g.cache.AddUser(token, user)
+		return user, nil
+	})
+	
+	var result singleflight.Result
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	
+	// Only the caller that ran the validation counts as a miss
+	if executed {
+		g.metrics.RecordTokenLookup("miss")
+	} else {
+		g.metrics.RecordTokenLookup("coalesced")
+	}
+	
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	return result.Val.(*pocketbase.User), nil
+}
+
+// getRole returns a role from the cache, falling back to PocketBase on a miss
+func (g *ApiGateway) getRole(ctx context.Context, id string) (*pocketbase.Role, error) {
+	if role := g.cache.GetRoleByID(id); role != nil {
+		return role, nil
+	}
+	
+	// Role not in cache, try to get from PocketBase
+	role, err := g.store.GetRoleByIDContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Add role to cache
+	g.cache.AddRole(role.ID, role)
+	return role, nil
+}
+
+// getRoleHierarchy returns a role and makes sure all of its ancestors
+// are cached so that inherited permissions can be resolved
+func (g *ApiGateway) getRoleHierarchy(ctx context.Context, id string) (*pocketbase.Role, error) {
+	role, err := g.getRole(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Walk up the parent chain, stopping at the first cycle
+	visited := map[string]bool{role.ID: true}
+	for parentID := role.ParentID; parentID != "" && !visited[parentID]; {
+		visited[parentID] = true
+		
+		parent, err := g.getRole(ctx, parentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get parent role %s: %w", parentID, err)
+		}
+		parentID = parent.ParentID
+	}
+	
+	return role, nil
+}
+
+// mergeRolePermissions returns the union of the effective publish and
+// subscribe permissions of all given roles, without duplicates and in role order
+func mergeRolePermissions(roles []*cache.EffectivePermissions) ([]string, []string) {
+	var publishPermissions, subscribePermissions []string
+	seenPublish := make(map[string]bool)
+	seenSubscribe := make(map[string]bool)
+	
+	for _, role := range roles {
+		for _, pattern := range role.Publish {
+			if !seenPublish[pattern] {
+				seenPublish[pattern] = true
+				publishPermissions = append(publishPermissions, pattern)
+			}
+		}
+		
+		for _, pattern := range role.Subscribe {
+			if !seenSubscribe[pattern] {
+				seenSubscribe[pattern] = true
+				subscribePermissions = append(subscribePermissions, pattern)
+			}
+		}
+	}
+	
+	return publishPermissions, subscribePermissions
+}
+
+// explainInheritance summarizes which permissions each role inherited
+// and from which ancestor, for permission debug logging
+func explainInheritance(roles []*cache.EffectivePermissions) map[string]interface{} {
+	explanation := make(map[string]interface{})
+	for _, role := range roles {
+		if len(role.Ancestors) == 0 {
+			continue
+		}
+		explanation[role.RoleName] = map[string]interface{}{
+			"ancestors": role.Ancestors,
+			"publish":   role.InheritedPublish,
+			"subscribe": role.InheritedSubscribe,
+		}
+	}
+	return explanation
+}
+
+// newRouteProxy creates the reverse proxy forwarding a route's requests to
+// targetURL, applying the route's header rules
+func (g *ApiGateway) newRouteProxy(route config.Route, targetURL *url.URL, headers routeHeaderRules) (*httputil.ReverseProxy, error) {
+	// Create a reverse proxy
+	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	
+	// Store original director function
+	originalDirector := proxy.Director
+	rewrites, err := newRewriteRules(route.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+	requestHeaders, responseHeaders := headers.request, headers.response
+	
+	// Create a custom director function
+	proxy.Director = func(req *http.Request) {
+		// Rewrite the path before it is joined with the target's path
+		originalPath := req.URL.Path
+		rewritten := rewriteURL(rewrites, req.URL)
+		
+		// Call the original director
+		originalDirector(req)
+		
+		// Strip the prefix if configured and no rewrite rule matched
+		if route.StripPrefix && !rewritten {
+			req.URL.Path = strings.TrimPrefix(req.URL.Path, route.PathPrefix)
+			if !strings.HasPrefix(req.URL.Path, "/") {
+				req.URL.Path = "/" + req.URL.Path
+			}
+			// Keep the escaped form in sync with the canonical path
+			req.URL.RawPath = ""
+		}
+		
+		// Forward the user ID if available
+		user, hasUser := req.Context().Value("user").(*pocketbase.User)
+		if hasUser {
+			req.Header.Set("X-User-ID", user.ID)
+			req.Header.Set("X-Username", user.Username)
+		}
+		
+		// Forward configured extra user fields; client-supplied values are never passed through
+		for _, extra := range g.extraUserFields {
+			if extra.Header == "" {
+				continue
+			}
+			req.Header.Del(extra.Header)
+			if hasUser {
+				if value, found := user.Extra[extra.Field]; found {
+					req.Header.Set(extra.Header, value)
+				}
+			}
+		}
+		
+		// Forward the primary role if available
+		if role, ok := req.Context().Value("role").(*pocketbase.Role); ok {
+			req.Header.Set("X-Role-ID", role.ID)
+			req.Header.Set("X-Role-Name", role.Name)
+		}
+		
+		// Forward all roles as comma-separated lists
+		if roles, ok := req.Context().Value("roles").([]*pocketbase.Role); ok {
+			roleIDs := make([]string, len(roles))
+			roleNames := make([]string, len(roles))
+			for i, role := range roles {
+				roleIDs[i] = role.ID
+				roleNames[i] = role.Name
+			}
+			req.Header.Set("X-Role-IDs", strings.Join(roleIDs, ","))
+			req.Header.Set("X-Role-Names", strings.Join(roleNames, ","))
+		}
+		
+		// Forward the signed identity assertion; a client-supplied one is never passed through
+		if g.assertions != nil {
+			req.Header.Del(g.assertionHeader)
+			if hasUser {
+				if token, err := g.signAssertion(req, user); err != nil {
+					g.logger.Error("Failed to sign identity assertion", zap.Error(err))
+				} else {
+					req.Header.Set(g.assertionHeader, token)
+				}
+			}
+		}
+		
+		// Apply the route's header rules last, so they can rename or drop the gateway's headers
+		if !requestHeaders.empty() {
+			requestHeaders.applyRequest(req, headerVarsFrom(req.Context()))
+		}
+		
+		if rewritten {
+			g.logger.Debug("Proxying rewritten request", 
+				zap.String("original_path", originalPath),
+				zap.String("path", req.URL.Path),
+				zap.String("query", req.URL.RawQuery),
+				zap.String("target", targetURL.String()))
+		} else {
+			g.logger.Debug("Proxying request", 
+				zap.String("path", req.URL.Path),
+				zap.String("target", targetURL.String()))
+		}
+	}
+	
+	// Apply the route's response header rules
+	if !responseHeaders.empty() {
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			responseHeaders.apply(resp.Header, headerVarsFrom(resp.Request.Context()))
+			return nil
+		}
+	}
+	
+	// Set up error handler
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		// A streamed body went over the limit while being sent upstream
+		if isBodyTooLarge(err) {
+			g.logger.Debug("Rejected oversized request",
+				zap.String("path", r.URL.Path),
+				zap.String("method", r.Method),
+				zap.String("limit", limitBody))
+			
+			g.metrics.RecordRequestLimitRejection(limitBody)
+			g.sendError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		
+		g.logger.Error("Proxy error",
+			zap.Error(err),
+			zap.String("path", r.URL.Path),
+			zap.String("method", r.Method),
+			zap.String("target", targetURL.String()))
+		
+		g.sendError(w, http.StatusBadGateway, "backend service error")
+	}
+	
+	return proxy, nil
+}
+
 // setupProxyRoutes configures the proxy routes from the configuration
 func (g *ApiGateway) setupProxyRoutes() error {
-	// Create separate maps for protected and unprotected route handlers
-	protectedRouteMap := make(map[string]*http.Handler)
-	unprotectedRouteMap := make(map[string]*http.Handler)
+	proxyRoutes := make([]*proxyRoute, 0, len(g.routes))
 	
 	// First, set up all the proxy handlers
-	for _, route := range g.routes {
+	for i, route := range g.routes {
 		targetURL, err := url.Parse(route.TargetURL)
 		if err != nil {
 			return fmt.Errorf("invalid target URL %s: %w", route.TargetURL, err)
@@ -291,89 +964,96 @@ func (g *ApiGateway) setupProxyRoutes() error {
 			zap.Bool("stripPrefix", route.StripPrefix),
 			zap.Bool("protected", route.Protected))
 		
-		// Create a reverse proxy
-		proxy := httputil.NewSingleHostReverseProxy(targetURL)
-		
-		// Store original director function
-		originalDirector := proxy.Director
-		
-		// Create a custom director function
-		proxy.Director = func(req *http.Request) {
-			// Call the original director
-			originalDirector(req)
-			
-			// Strip the prefix if configured
-			if route.StripPrefix {
-				req.URL.Path = strings.TrimPrefix(req.URL.Path, route.PathPrefix)
-				if !strings.HasPrefix(req.URL.Path, "/") {
-					req.URL.Path = "/" + req.URL.Path
-				}
+		// Create the reverse proxy, or one per variant if traffic is split
+		headers := newRouteHeaderRules(route)
+		var (
+			handler http.Handler
+			split   *trafficSplit
+		)
+		if len(route.Split.Variants) > 0 {
+			split, err = g.newTrafficSplit(route, targetURL, headers)
+			if err != nil {
+				return fmt.Errorf("route %s: %w", route.PathPrefix, err)
 			}
-			
-			// Forward the user ID if available
-			if user, ok := req.Context().Value("user").(*pocketbase.User); ok {
-				req.Header.Set("X-User-ID", user.ID)
-				req.Header.Set("X-Username", user.Username)
+			handler = g.splitHandler(split)
+		} else {
+			handler, err = g.newRouteProxy(route, targetURL, headers)
+			if err != nil {
+				return fmt.Errorf("route %s: %w", route.PathPrefix, err)
 			}
-			
-			// Forward the role if available
-			if role, ok := req.Context().Value("role").(*pocketbase.Role); ok {
-				req.Header.Set("X-Role-ID", role.ID)
-				req.Header.Set("X-Role-Name", role.Name)
+		}
+		
+		// Copy a sample of the requests to the shadow upstream
+		if route.Mirror.TargetURL != "" {
+			mirror, err := g.newMirror(route, headers)
+			if err != nil {
+				return fmt.Errorf("route %s: %w", route.PathPrefix, err)
 			}
-			
-			g.logger.Debug("Proxying request", 
-				zap.String("path", req.URL.Path),
-				zap.String("target", targetURL.String()))
+			handler = g.mirrorHandler(mirror, handler)
 		}
 		
-		// Set up error handler
-		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-			g.logger.Error("Proxy error",
-				zap.Error(err),
-				zap.String("path", r.URL.Path),
-				zap.String("method", r.Method))
-			
-			g.sendError(w, http.StatusBadGateway, "backend service error")
+		// Collapse identical concurrent requests into one upstream request
+		if route.Coalesce.Enabled {
+			handler = g.coalesceHandler(g.newCoalescer(i, route), handler)
 		}
 		
-		// Store the proxy handler in the appropriate map based on protection status
-		handler := http.Handler(proxy)
+		// Serve cached responses; cache hits are neither proxied nor mirrored
+		if route.Cache.Enabled {
+			handler = g.responseCacheHandler(g.newRouteCache(i, route), handler)
+		}
+		
+		// Choose the variant before the cache and coalescing, which key on it
+		if split != nil {
+			handler = g.chooseVariant(split, handler)
+		}
+		
+		// Capture the template values of the header rules from the incoming request
+		if !headers.empty() {
+			handler = withHeaderTemplateVars(handler)
+		}
+		
+		// Protected routes authenticate and authorize before proxying
 		if route.Protected {
-			protectedRouteMap[route.PathPrefix] = &handler
-		} else {
-			unprotectedRouteMap[route.PathPrefix] = &handler
+			handler = g.authMiddleware(handler)
 		}
+		proxyRoutes = append(proxyRoutes, &proxyRoute{route: route, handler: handler})
 	}
 	
-	// Set up unprotected routes first (no authentication required)
-	for pathPrefix, handler := range unprotectedRouteMap {
-		g.router.Handle(pathPrefix+"*", *handler)
-		g.logger.Debug("Registered unprotected route", zap.String("pathPrefix", pathPrefix))
-	}
-	
-	// Apply authentication middleware to protected routes
-	g.router.Group(func(r chi.Router) {
-		r.Use(g.authMiddleware)
-		
-		// Register specific protected routes
-		for pathPrefix, handler := range protectedRouteMap {
-			r.Handle(pathPrefix+"*", *handler)
-			g.logger.Debug("Registered protected route", zap.String("pathPrefix", pathPrefix))
+	// Try longer prefixes first, then more specific routes, then configuration order
+	sort.SliceStable(proxyRoutes, func(i, j int) bool {
+		a, b := &proxyRoutes[i].route, &proxyRoutes[j].route
+		if len(a.PathPrefix) != len(b.PathPrefix) {
+			return len(a.PathPrefix) > len(b.PathPrefix)
 		}
-		
-		// Add a catch-all route for any path that doesn't match defined routes
-		// This ensures that paths are properly rejected with 403 if not authorized
-		r.HandleFunc("/*", func(w http.ResponseWriter, r *http.Request) {
-			// If we reach here, it means the path didn't match any defined route
-			// The authMiddleware would have already checked permissions and rejected
-			// unauthorized requests, so this is a fallback for paths that aren't configured
-			g.logger.Warn("Request to undefined protected route", 
-				zap.String("path", r.URL.Path),
-				zap.String("method", r.Method))
-			g.sendError(w, http.StatusNotFound, "no route configured for this path")
-		})
+		return a.Specificity() > b.Specificity()
 	})
+	g.proxyRoutes = proxyRoutes
+	
+	// Paths without a matching route still require authentication, so
+	// unauthorized requests are rejected with 401/403 rather than 404
+	notFound := g.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.logger.Warn("Request to undefined protected route", 
+			zap.String("path", r.URL.Path),
+			zap.String("method", r.Method))
+		g.sendError(w, http.StatusNotFound, "no route configured for this path")
+	}))
+	
+	// Register each prefix once; the dispatcher picks the route by its conditions
+	registered := make(map[string]bool)
+	for _, proxyRoute := range proxyRoutes {
+		pathPrefix := proxyRoute.route.PathPrefix
+		if registered[pathPrefix] {
+			continue
+		}
+		registered[pathPrefix] = true
+		g.router.Handle(pathPrefix+"*", g.dispatch(notFound))
+		g.logger.Debug("Registered route prefix", zap.String("pathPrefix", pathPrefix))
+	}
+	
+	// Add a catch-all route for any path that doesn't match defined routes
+	if !registered["/"] {
+		g.router.Handle("/*", notFound)
+	}
 	
 	return nil
 }
@@ -382,25 +1062,43 @@ func (g *ApiGateway) setupProxyRoutes() error {
 func (g *ApiGateway) handleHealth(w http.ResponseWriter, r *http.Request) {
 	// Check PocketBase connection
 	pbStatus := "ok"
-	if _, err := g.pbClient.GetAllRoles(); err != nil {
+	if _, err := g.store.GetAllRolesContext(r.Context()); err != nil {
 		pbStatus = "error: " + err.Error()
 	}
 	
 	// Check cache status
 	cacheStats := g.cache.GetStats()
+	cacheStatus := map[string]interface{}{
+		"stale": g.cache.IsStale(),
+	}
+	if lastRefresh := g.cache.LastRefresh(); !lastRefresh.IsZero() {
+		cacheStatus["lastRefresh"] = lastRefresh.Format(time.RFC3339)
+		cacheStatus["ageSeconds"] = int(time.Since(lastRefresh).Seconds())
+	}
+	
+	// Serving from a snapshot is degraded, but still healthy enough to take traffic
+	status := "ok"
+	degradedSince := g.degradedAt()
+	if !degradedSince.IsZero() {
+		status = "degraded"
+	}
 	
 	// Send response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	
 	response := map[string]interface{}{
-		"status": "ok",
+		"status": status,
 		"components": map[string]string{
 			"pocketbase": pbStatus,
 		},
 		"cache": cacheStats,
+		"cacheRefresh": cacheStatus,
 		"timestamp": time.Now().Format(time.RFC3339),
 	}
+	if !degradedSince.IsZero() {
+		response["degradedSince"] = degradedSince.Format(time.RFC3339)
+	}
 	
 	json.NewEncoder(w).Encode(response)
 }
@@ -414,6 +1112,7 @@ func (g *ApiGateway) loggingMiddleware(next http.Handler) http.Handler {
 		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 		
 		// Process the request
+		r, variant := withVariantLogging(r)
 		next.ServeHTTP(ww, r)
 		
 		// Log the request
@@ -422,28 +1121,26 @@ func (g *ApiGateway) loggingMiddleware(next http.Handler) http.Handler {
 		// Extract request ID if available
 		requestID := middleware.GetReqID(r.Context())
 		
+		fields := []zap.Field{
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", ww.Status()),
+			zap.Duration("duration", duration),
+			zap.String("request_id", requestID),
+		}
+		
+		// Include the variant if the route splits its traffic
+		if *variant != "" {
+			fields = append(fields, zap.String("variant", *variant))
+		}
+		
 		// Determine log level based on status code
 		if ww.Status() >= 500 {
-			g.logger.Error("Request completed with server error",
-				zap.String("method", r.Method),
-				zap.String("path", r.URL.Path),
-				zap.Int("status", ww.Status()),
-				zap.Duration("duration", duration),
-				zap.String("request_id", requestID))
+			g.logger.Error("Request completed with server error", fields...)
 		} else if ww.Status() >= 400 {
-			g.logger.Warn("Request completed with client error",
-				zap.String("method", r.Method),
-				zap.String("path", r.URL.Path),
-				zap.Int("status", ww.Status()),
-				zap.Duration("duration", duration),
-				zap.String("request_id", requestID))
+			g.logger.Warn("Request completed with client error", fields...)
 		} else {
-			g.logger.Info("Request completed successfully",
-				zap.String("method", r.Method),
-				zap.String("path", r.URL.Path),
-				zap.Int("status", ww.Status()),
-				zap.Duration("duration", duration),
-				zap.String("request_id", requestID))
+			g.logger.Info("Request completed successfully", fields...)
 		}
 	})
 }
@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/store"
+)
+
+// testStore is the identity store of test gateways: alice's API key is
+// "alice-key"
+var testStore = `
+roles:
+  - id: r1
+    name: reader
+    publish_permissions: ["#"]
+    subscribe_permissions: ["#"]
+users:
+  - id: u1
+    username: alice
+    role_id: r1
+    api_keys: ["` + store.HashAPIKey("alice-key") + `"]
+`
+
+// newTestGateway starts a gateway with the given JSON configuration, in
+// which $UPSTREAM stands for the URL of upstream and $STORE for the
+// identity store file
+func newTestGateway(t *testing.T, cfgJSON string, upstream *httptest.Server) *ApiGateway {
+	t.Helper()
+
+	// Every gateway registers its metrics anew
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	dir := t.TempDir()
+	storePath := filepath.Join(dir, "store.yaml")
+	if err := os.WriteFile(storePath, []byte(testStore), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfgJSON = strings.NewReplacer("$UPSTREAM", upstream.URL, "$STORE", storePath).Replace(cfgJSON)
+	cfgPath := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(cfgPath, []byte(cfgJSON), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.LoadConfig(cfgPath, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := New(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(g.Close)
+	return g
+}
+
+// serve sends a request through the gateway and returns the recorded response
+func serve(g *ApiGateway, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, r)
+	return rec
+}
+
+// receive waits for a value from ch
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		var zero T
+		return zero
+	}
+}
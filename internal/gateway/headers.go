@@ -0,0 +1,272 @@
+// Package gateway implements the core API gateway functionality
+package gateway
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/pocketbase"
+)
+
+// headerTemplate matches ${...} placeholders in header values
+var headerTemplate = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// headerRules are a route's header rules with canonical header names
+type headerRules struct {
+	add    map[string]string
+	remove []string
+	rename map[string]string
+	
+	// Host rewrite for requests: host is the templated value of an added
+	// Host header, resetHost is set when Host is removed
+	host      string
+	hasHost   bool
+	resetHost bool
+}
+
+// routeHeaderRules are the request and response header rules of a route,
+// built once and shared by the route's proxies
+type routeHeaderRules struct {
+	request  headerRules
+	response headerRules
+}
+
+// newRouteHeaderRules builds the header rules of a route
+func newRouteHeaderRules(route config.Route) routeHeaderRules {
+	return routeHeaderRules{
+		request:  newHeaderRules(route.RequestHeaders),
+		response: newHeaderRules(route.ResponseHeaders),
+	}
+}
+
+// empty reports whether the route has no header rules at all
+func (h routeHeaderRules) empty() bool {
+	return h.request.empty() && h.response.empty()
+}
+
+// newHeaderRules canonicalizes the header names of configured rules
+func newHeaderRules(rules config.HeaderRules) headerRules {
+	compiled := headerRules{
+		add:    make(map[string]string, len(rules.Add)),
+		remove: make([]string, 0, len(rules.Remove)),
+		rename: make(map[string]string, len(rules.Rename)),
+	}
+	for name, value := range rules.Add {
+		name = http.CanonicalHeaderKey(name)
+		if name == "Host" {
+			compiled.host, compiled.hasHost = value, true
+			continue
+		}
+		compiled.add[name] = value
+	}
+	for _, name := range rules.Remove {
+		name = http.CanonicalHeaderKey(name)
+		if name == "Host" {
+			compiled.resetHost = true
+			continue
+		}
+		compiled.remove = append(compiled.remove, name)
+	}
+	for from, to := range rules.Rename {
+		compiled.rename[http.CanonicalHeaderKey(from)] = http.CanonicalHeaderKey(to)
+	}
+	return compiled
+}
+
+// empty reports whether the rules change nothing
+func (h headerRules) empty() bool {
+	return len(h.add) == 0 && len(h.remove) == 0 && len(h.rename) == 0 && !h.hasHost && !h.resetHost
+}
+
+// apply removes, renames and adds headers in that order. Added values are
+// expanded with vars; a value referring to a missing variable is not added.
+func (h headerRules) apply(header http.Header, vars *headerTemplateVars) {
+	for _, name := range h.remove {
+		header.Del(name)
+	}
+	
+	// Collect renamed values first so renames can swap headers
+	renamed := make(map[string][]string, len(h.rename))
+	for from, to := range h.rename {
+		if values, found := header[from]; found {
+			renamed[to] = values
+			header.Del(from)
+		}
+	}
+	for to, values := range renamed {
+		header[to] = values
+	}
+	
+	for name, value := range h.add {
+		if expanded, ok := vars.expand(value); ok {
+			header.Set(name, expanded)
+		} else {
+			header.Del(name)
+		}
+	}
+}
+
+// applyRequest applies the rules to an outgoing request, including the Host rewrite
+func (h headerRules) applyRequest(req *http.Request, vars *headerTemplateVars) {
+	h.apply(req.Header, vars)
+	
+	// Without a Host, the transport uses the target URL's host
+	if h.resetHost {
+		req.Host = ""
+	}
+	if h.hasHost {
+		if host, ok := vars.expand(h.host); ok {
+			req.Host = host
+		}
+	}
+}
+
+// headerTemplateVars resolves header template placeholders for one request
+type headerTemplateVars struct {
+	values map[string]string // user.*, role.* and request.* values
+	header http.Header       // Incoming request headers for request.header.*
+}
+
+// newHeaderTemplateVars captures the incoming request and principal. It must
+// be called before the request is rewritten for the upstream.
+func newHeaderTemplateVars(r *http.Request) *headerTemplateVars {
+	values := map[string]string{
+		"request.method":   r.Method,
+		"request.host":     r.Host,
+		"request.path":     r.URL.Path,
+		"request.query":    r.URL.RawQuery,
+		"request.clientIp": clientIP(r),
+		"request.id":       middleware.GetReqID(r.Context()),
+	}
+	
+	if user, ok := r.Context().Value("user").(*pocketbase.User); ok {
+		for name, value := range templateVars(user) {
+			values[name] = value
+		}
+	}
+	if role, ok := r.Context().Value("role").(*pocketbase.Role); ok {
+		values["role.id"] = role.ID
+		values["role.name"] = role.Name
+	}
+	
+	return &headerTemplateVars{values: values, header: r.Header}
+}
+
+// lookup returns the value of a placeholder
+func (v *headerTemplateVars) lookup(name string) (string, bool) {
+	if v == nil {
+		return "", false
+	}
+	if header, found := strings.CutPrefix(name, "request.header."); found {
+		values := v.header.Values(header)
+		return strings.Join(values, ","), len(values) > 0
+	}
+	if variable, found := strings.CutPrefix(name, "env."); found {
+		return os.LookupEnv(variable)
+	}
+	value, found := v.values[name]
+	return value, found
+}
+
+// expand substitutes placeholders in a header value. It reports false if a
+// placeholder has no value.
+func (v *headerTemplateVars) expand(value string) (string, bool) {
+	ok := true
+	expanded := headerTemplate.ReplaceAllStringFunc(value, func(placeholder string) string {
+		replacement, found := v.lookup(headerTemplate.FindStringSubmatch(placeholder)[1])
+		if !found {
+			ok = false
+		}
+		return replacement
+	})
+	return expanded, ok
+}
+
+// clientIP returns the client address without the port; RealIP has already
+// applied X-Forwarded-For and X-Real-IP
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// withHeaderTemplateVars captures the header template values of the incoming
+// request for the route's Director and ModifyResponse
+func withHeaderTemplateVars(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), "headerVars", newHeaderTemplateVars(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// headerVarsFrom returns the values captured by withHeaderTemplateVars
+func headerVarsFrom(ctx context.Context) *headerTemplateVars {
+	vars, _ := ctx.Value("headerVars").(*headerTemplateVars)
+	return vars
+}
+
+// stripReservedHeaders removes headers only the gateway may set from incoming requests
+func (g *ApiGateway) stripReservedHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range g.reservedHeaders {
+			r.Header.Del(name)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// collectReservedHeaders returns every header the gateway sets itself plus
+// the configured reserved headers, deduplicated and canonicalized
+func collectReservedHeaders(cfg *config.Config) []string {
+	names := append([]string{}, config.DefaultReservedHeaders...)
+	names = append(names, cfg.Headers.Reserved...)
+	for _, extra := range cfg.PocketBase.FieldMapping.User.Extra {
+		if extra.Header != "" {
+			names = append(names, extra.Header)
+		}
+	}
+	if cfg.Assertion.Enabled {
+		names = append(names, cfg.Assertion.Header)
+	}
+	return uniqueHeaderNames(names)
+}
+
+// collectCredentialHeaders returns the default credential headers plus the
+// configured ones, deduplicated and canonicalized
+func collectCredentialHeaders(cfg *config.Config) []string {
+	names := append([]string{}, config.DefaultCredentialHeaders...)
+	return uniqueHeaderNames(append(names, cfg.Headers.Credentials...))
+}
+
+// uniqueHeaderNames canonicalizes header names and drops duplicates
+func uniqueHeaderNames(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	unique := make([]string, 0, len(names))
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
+
+// hasCredentials reports whether a request carries any of the credential headers
+func hasCredentials(r *http.Request, credentialHeaders []string) bool {
+	for _, name := range credentialHeaders {
+		if r.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReservedHeadersKeepIdentityHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	g := newTestGateway(t, `{
+		"store": {"type": "file", "file": {"path": "$STORE"}},
+		"headers": {"reserved": ["X-Forwarded-User"]},
+		"routes": [{"pathPrefix": "/pub/", "targetUrl": "$UPSTREAM", "protected": false}]
+	}`, upstream)
+
+	req := httptest.NewRequest(http.MethodGet, "/pub/profile", nil)
+	req.Header.Set("X-User-ID", "u1")
+	req.Header.Set("X-Role-Names", "admin")
+	req.Header.Set("X-Forwarded-User", "alice")
+	req.Header.Set("X-Request-Source", "test")
+	if rec := serve(g, req); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d", rec.Code)
+	}
+
+	for _, name := range []string{"X-User-ID", "X-Role-Names", "X-Forwarded-User"} {
+		if value := got.Get(name); value != "" {
+			t.Errorf("reserved header %s reached the upstream as %q", name, value)
+		}
+	}
+	if got.Get("X-Request-Source") != "test" {
+		t.Error("unreserved header was stripped")
+	}
+}
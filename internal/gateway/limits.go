@@ -0,0 +1,110 @@
+// Package gateway implements the core API gateway functionality
+package gateway
+
+import (
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"api-gateway/internal/config"
+)
+
+// Limits exceeded by rejected requests, as reported in metrics
+const (
+	limitBody    = "body"
+	limitHeaders = "headers"
+	limitURL     = "url"
+)
+
+// requestLimits returns the limits applying to a request: those of its
+// route, with the global limits where the route sets none
+func (g *ApiGateway) requestLimits(r *http.Request) config.RequestLimits {
+	if proxyRoute := g.matchRoute(r); proxyRoute != nil {
+		return proxyRoute.route.Limits.Merge(g.limits)
+	}
+	return g.limits
+}
+
+// headerBytes approximates the size of the request headers as sent on the
+// wire, counting each line as "Name: value\r\n"
+func headerBytes(r *http.Request) int {
+	size := len("Host: \r\n") + len(r.Host)
+	for name, values := range r.Header {
+		for _, value := range values {
+			size += len(name) + len(value) + len(": \r\n")
+		}
+	}
+	return size
+}
+
+// limitsMiddleware rejects requests exceeding the largest limits of any
+// route. It runs before path normalization, when the route isn't known yet,
+// so that oversized requests are turned away before any work is done on
+// them; routeLimitsMiddleware then applies the route's own limits.
+func (g *ApiGateway) limitsMiddleware(next http.Handler) http.Handler {
+	return g.enforceLimits(next, func(*http.Request) config.RequestLimits {
+		return g.maxLimits
+	})
+}
+
+// routeLimitsMiddleware rejects requests exceeding the limits of the route
+// their normalized path matches
+func (g *ApiGateway) routeLimitsMiddleware(next http.Handler) http.Handler {
+	return g.enforceLimits(next, g.requestLimits)
+}
+
+// globalLimitsMiddleware rejects requests exceeding the global limits, on
+// listeners serving no routes
+func (g *ApiGateway) globalLimitsMiddleware(next http.Handler) http.Handler {
+	return g.enforceLimits(next, func(*http.Request) config.RequestLimits {
+		return g.limits
+	})
+}
+
+// enforceLimits rejects requests whose URL, headers or declared body exceed
+// their limits, and caps the body of the others so that a streamed body
+// can't exceed the limit either
+func (g *ApiGateway) enforceLimits(next http.Handler, limitsOf func(*http.Request) config.RequestLimits) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limits := limitsOf(r)
+		
+		reject := func(limit string, status int, message string) {
+			g.logger.Debug("Rejected oversized request",
+				zap.String("path", r.URL.Path),
+				zap.String("method", r.Method),
+				zap.String("limit", limit))
+			
+			g.metrics.RecordRequestLimitRejection(limit)
+			g.sendError(w, status, message)
+		}
+		
+		if limits.MaxURLBytes > 0 && len(r.RequestURI) > limits.MaxURLBytes {
+			reject(limitURL, http.StatusRequestURITooLong, "request URL too long")
+			return
+		}
+		if limits.MaxHeaderBytes > 0 && headerBytes(r) > limits.MaxHeaderBytes {
+			reject(limitHeaders, http.StatusRequestHeaderFieldsTooLarge, "request headers too large")
+			return
+		}
+		
+		if limits.MaxBodyBytes > 0 && r.Body != nil && r.Body != http.NoBody {
+			if r.ContentLength > limits.MaxBodyBytes {
+				// Close the connection rather than drain the body
+				w.Header().Set("Connection", "close")
+				reject(limitBody, http.StatusRequestEntityTooLarge, "request body too large")
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes)
+		}
+		
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isBodyTooLarge reports whether a proxy error was caused by a streamed
+// request body exceeding its limit
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
@@ -0,0 +1,261 @@
+// Package gateway implements the core API gateway functionality
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+
+	"api-gateway/internal/config"
+)
+
+// Results of mirrored requests, as reported in metrics
+const (
+	mirrorMatch          = "match"
+	mirrorStatusMismatch = "status_mismatch"
+	mirrorBodyMismatch   = "body_mismatch"
+	mirrorError          = "error"
+	mirrorDropped        = "dropped"
+	mirrorTooLarge       = "too_large"
+)
+
+// mirror copies sampled requests of a route to a shadow upstream
+type mirror struct {
+	route        string // Path prefix, for logs and metrics
+	proxy        *httputil.ReverseProxy
+	sampleRate   float64
+	maxBodyBytes int64
+	timeout      time.Duration
+	slots        chan struct{} // One per shadow request in flight
+}
+
+// newMirror creates the proxy to the route's shadow upstream. It applies the
+// same rewrites and headers as the primary's.
+func (g *ApiGateway) newMirror(route config.Route, headers routeHeaderRules) (*mirror, error) {
+	targetURL, err := url.Parse(route.Mirror.TargetURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mirror target URL %s: %w", route.Mirror.TargetURL, err)
+	}
+	
+	proxy, err := g.newRouteProxy(route, targetURL, headers)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Shadow errors are only counted, never sent anywhere
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if shadow, ok := w.(*shadowWriter); ok {
+			shadow.err = err
+		}
+	}
+	
+	m := &mirror{
+		route:        route.PathPrefix,
+		proxy:        proxy,
+		sampleRate:   route.Mirror.SampleRate,
+		maxBodyBytes: route.Mirror.MaxBodyBytes,
+		timeout:      time.Duration(route.Mirror.TimeoutSeconds) * time.Second,
+	}
+	if m.maxBodyBytes == 0 {
+		m.maxBodyBytes = config.DefaultMirrorMaxBodyBytes
+	}
+	if m.timeout == 0 {
+		m.timeout = config.DefaultMirrorTimeoutSeconds * time.Second
+	}
+	maxInFlight := route.Mirror.MaxInFlight
+	if maxInFlight == 0 {
+		maxInFlight = config.DefaultMirrorMaxInFlight
+	}
+	m.slots = make(chan struct{}, maxInFlight)
+	
+	g.logger.Info("Set up route mirror",
+		zap.String("pathPrefix", route.PathPrefix),
+		zap.String("targetURL", targetURL.String()),
+		zap.Float64("sampleRate", m.sampleRate),
+		zap.Int64("maxBodyBytes", m.maxBodyBytes))
+	
+	return m, nil
+}
+
+// mirrorHandler serves requests with next and copies a sample of them to the
+// shadow upstream in the background
+func (g *ApiGateway) mirrorHandler(m *mirror, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rand.Float64() >= m.sampleRate {
+			next.ServeHTTP(w, r)
+			return
+		}
+		
+		// The body is read once, so buffer it for both upstreams
+		var body []byte
+		if r.Body != nil && r.Body != http.NoBody {
+			if r.ContentLength > m.maxBodyBytes {
+				g.metrics.RecordMirror(m.route, mirrorTooLarge)
+				next.ServeHTTP(w, r)
+				return
+			}
+			
+			buffered, err := io.ReadAll(io.LimitReader(r.Body, m.maxBodyBytes+1))
+			if err != nil || int64(len(buffered)) > m.maxBodyBytes {
+				// Hand the primary what was read followed by the rest
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(buffered), r.Body), r.Body}
+				if err == nil {
+					g.metrics.RecordMirror(m.route, mirrorTooLarge)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			body = buffered
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		
+		// Drop the copy rather than queue it if the shadow is falling behind
+		select {
+		case m.slots <- struct{}{}:
+		default:
+			g.metrics.RecordMirror(m.route, mirrorDropped)
+			next.ServeHTTP(w, r)
+			return
+		}
+		
+		// The shadow request outlives the client's, but not the timeout
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), m.timeout)
+		shadowReq := r.Clone(ctx)
+		shadowReq.Body = http.NoBody
+		if body != nil {
+			shadowReq.Body = io.NopCloser(bytes.NewReader(body))
+			shadowReq.ContentLength = int64(len(body))
+		}
+		
+		primary := &responseDigest{hash: sha256.New()}
+		primaryDone := make(chan struct{})
+		go g.sendShadow(m, shadowReq, cancel, primary, primaryDone)
+		
+		defer close(primaryDone)
+		next.ServeHTTP(&digestWriter{ResponseWriter: w, digest: primary}, r)
+	})
+}
+
+// sendShadow sends a copied request to the shadow upstream, then compares
+// its response with the primary's once that is complete
+func (g *ApiGateway) sendShadow(m *mirror, req *http.Request, cancel context.CancelFunc, primary *responseDigest, primaryDone <-chan struct{}) {
+	defer func() { <-m.slots }()
+	defer cancel()
+	
+	shadow := &shadowWriter{header: make(http.Header), digest: responseDigest{hash: sha256.New()}}
+	func() {
+		// The proxy aborts with a panic if the response body fails midway
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				shadow.err = fmt.Errorf("shadow response aborted: %v", recovered)
+			}
+		}()
+		m.proxy.ServeHTTP(shadow, req)
+	}()
+	
+	<-primaryDone
+	
+	result := mirrorMatch
+	switch {
+	case shadow.err != nil:
+		result = mirrorError
+	case shadow.digest.status != primary.status:
+		result = mirrorStatusMismatch
+	case !bytes.Equal(shadow.digest.hash.Sum(nil), primary.hash.Sum(nil)):
+		result = mirrorBodyMismatch
+	}
+	g.metrics.RecordMirror(m.route, result)
+	
+	if result != mirrorMatch {
+		g.logger.Debug("Shadow response diverged",
+			zap.String("pathPrefix", m.route),
+			zap.String("method", req.Method),
+			zap.String("path", req.URL.Path),
+			zap.String("result", result),
+			zap.Int("primary_status", primary.status),
+			zap.Int("shadow_status", shadow.digest.status),
+			zap.Error(shadow.err))
+	}
+}
+
+// responseDigest records the status of a response and hashes its body
+type responseDigest struct {
+	status int
+	hash   hash.Hash
+}
+
+// writeHeader records the final status, ignoring informational responses
+func (d *responseDigest) writeHeader(status int) {
+	if d.status == 0 && status >= 200 {
+		d.status = status
+	}
+}
+
+// write hashes part of the body
+func (d *responseDigest) write(p []byte) {
+	if d.status == 0 {
+		d.status = http.StatusOK
+	}
+	d.hash.Write(p)
+}
+
+// digestWriter passes the primary's response to the client while recording it
+type digestWriter struct {
+	http.ResponseWriter
+	digest *responseDigest
+}
+
+func (w *digestWriter) WriteHeader(status int) {
+	w.digest.writeHeader(status)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *digestWriter) Write(p []byte) (int, error) {
+	w.digest.write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush keeps streamed responses flowing
+func (w *digestWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the client's writer
+func (w *digestWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// shadowWriter records the shadow's response and discards it
+type shadowWriter struct {
+	header http.Header
+	digest responseDigest
+	err    error
+}
+
+func (w *shadowWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *shadowWriter) WriteHeader(status int) {
+	w.digest.writeHeader(status)
+}
+
+func (w *shadowWriter) Write(p []byte) (int, error) {
+	w.digest.write(p)
+	return len(p), nil
+}
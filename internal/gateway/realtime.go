@@ -0,0 +1,82 @@
+// Package gateway implements the core API gateway functionality
+package gateway
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"api-gateway/internal/pocketbase"
+)
+
+// runRealtimeSubscription keeps a PocketBase realtime subscription open and
+// applies user and role changes to the cache as they arrive. The periodic
+// refresh keeps running, so the cache still converges while disconnected.
+func (g *ApiGateway) runRealtimeSubscription(minBackoff, maxBackoff time.Duration) {
+	defer g.backgroundWG.Done()
+	defer g.metrics.SetRealtimeConnected(false)
+	
+	g.pbClient.SubscribeCollections(g.background, pocketbase.RealtimeOptions{
+		MinBackoff: minBackoff,
+		MaxBackoff: maxBackoff,
+		OnConnect: func(reconnect bool) {
+			g.metrics.SetRealtimeConnected(true)
+			
+			// Events may have been missed while disconnected, so resync
+			if reconnect {
+				if err := g.refreshCache(); err != nil {
+					g.logger.Warn("Failed to resync cache after realtime reconnect", zap.Error(err))
+				}
+			}
+		},
+		OnDisconnect: func(err error) {
+			g.metrics.SetRealtimeConnected(false)
+		},
+	}, g.applyRealtimeEvent)
+}
+
+// applyRealtimeEvent applies a single user or role change to the cache
+func (g *ApiGateway) applyRealtimeEvent(event pocketbase.RealtimeEvent) {
+	switch event.Collection {
+	case g.pbClient.RoleCollection():
+		role, err := event.Role()
+		if err != nil {
+			g.logger.Warn("Ignoring realtime role event", zap.Error(err))
+			return
+		}
+		
+		if event.Action == pocketbase.ActionDelete {
+			g.cache.RemoveRole(role.ID)
+		} else {
+			g.cache.AddRole(role.ID, role)
+		}
+		
+		g.logger.Info("Applied realtime role change",
+			zap.String("action", event.Action),
+			zap.String("role", role.Name))
+		
+	case g.pbClient.UserCollection():
+		user, err := event.User()
+		if err != nil {
+			g.logger.Warn("Ignoring realtime user event", zap.Error(err))
+			return
+		}
+		
+		// Deleted or deactivated users lose access immediately;
+		// other changes (e.g. role assignment) update cached tokens in place
+		if event.Action == pocketbase.ActionDelete || !user.Active {
+			removed := g.cache.RemoveUser(user.ID)
+			g.logger.Info("Revoked cached tokens after realtime user change",
+				zap.String("action", event.Action),
+				zap.String("user_id", user.ID),
+				zap.Int("tokens", removed))
+		} else {
+			g.cache.UpdateUser(user)
+		}
+		
+	default:
+		return
+	}
+	
+	g.metrics.RecordRealtimeEvent(event.Collection, event.Action)
+}
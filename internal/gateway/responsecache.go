@@ -0,0 +1,363 @@
+// Package gateway implements the core API gateway functionality
+package gateway
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/pocketbase"
+	"api-gateway/internal/responsecache"
+)
+
+// Results of response cache lookups, as reported in metrics and X-Cache
+const (
+	cacheHit         = "hit"
+	cacheRevalidated = "revalidated"
+	cacheMiss        = "miss"
+	cacheBypass      = "bypass"
+)
+
+// revalidationWindow is how long a stale response with an ETag or
+// Last-Modified is kept to be revalidated with the upstream
+const revalidationWindow = time.Hour
+
+// cacheableStatus are the statuses stored when the response headers allow it
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// revalidatedHeaders are the stored headers a 304 response replaces
+var revalidatedHeaders = []string{"Cache-Control", "Date", "ETag", "Expires", "Last-Modified", "Vary"}
+
+// routeCache is the response cache policy of a route
+type routeCache struct {
+	id            string // Distinguishes routes with the same prefix in cache keys
+	route         string // Path prefix, for metrics and purging
+	protected     bool
+	partition     string
+	ttl           time.Duration
+	maxEntryBytes int64
+	credentials   []string // Credential headers, see hasCredentials
+}
+
+// newRouteCache creates the cache policy of the route at index in the configuration
+func (g *ApiGateway) newRouteCache(index int, route config.Route) *routeCache {
+	c := &routeCache{
+		id:            fmt.Sprintf("%d:%s", index, route.PathPrefix),
+		route:         route.PathPrefix,
+		protected:     route.Protected,
+		partition:     route.Cache.Partition,
+		ttl:           time.Duration(route.Cache.TTLSeconds) * time.Second,
+		maxEntryBytes: g.maxCachedResponse,
+		credentials:   g.credentialHeaders,
+	}
+	if c.partition == "" {
+		c.partition = config.CachePartitionRole
+	}
+	
+	g.logger.Info("Caching route responses",
+		zap.String("pathPrefix", route.PathPrefix),
+		zap.String("partition", c.partition),
+		zap.Duration("ttl", c.ttl))
+	
+	return c
+}
+
+// key returns the cache key of a request with an entry carrying its
+// partition. Requests on protected routes are partitioned by the user or
+// their roles, requests with credentials on unprotected routes by their
+// credentials, and on split routes by the variant chosen for the request.
+// Returns false if the request can't be partitioned.
+func (c *routeCache) key(r *http.Request) (string, *responsecache.Entry, bool) {
+	entry := &responsecache.Entry{Route: c.route, Path: r.URL.Path}
+	partition := "public"
+	
+	if c.protected {
+		roleIDs, ok := sortedRoleIDs(r)
+		if !ok {
+			return "", nil, false
+		}
+		entry.RoleIDs = roleIDs
+		
+		if c.partition == config.CachePartitionUser {
+			user := r.Context().Value("user").(*pocketbase.User)
+			entry.UserID = user.ID
+			partition = "user:" + user.ID
+		} else {
+			partition = "role:" + strings.Join(entry.RoleIDs, ",")
+		}
+	} else if hasCredentials(r, c.credentials) {
+		// The upstream may answer according to credentials the gateway didn't
+		// check, so responses to them are never served to anyone else
+		partition = "credentials:" + c.credentialsDigest(r)
+	}
+	
+	key := strings.Join([]string{c.id, partition, requestVariant(r), r.Host, r.URL.Path, r.URL.RawQuery}, "\x00")
+	return key, entry, true
+}
+
+// credentialsDigest hashes the credential headers of a request, so that
+// cache keys don't hold the credentials themselves
+func (c *routeCache) credentialsDigest(r *http.Request) string {
+	hash := sha256.New()
+	for _, name := range c.credentials {
+		for _, value := range r.Header.Values(name) {
+			fmt.Fprintf(hash, "%s: %s\n", name, value)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// lifetime decides whether a response may be stored and for how long it is
+// fresh. Responses that are stale right away are only stored if they can
+// be revalidated. authorized is set if the request carried credentials.
+func (c *routeCache) lifetime(status int, header http.Header, authorized bool) (time.Duration, bool) {
+	if !cacheableStatus[status] || header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	
+	directives := responsecache.ParseCacheControl(header.Values("Cache-Control"))
+	if directives.Has("no-store") {
+		return 0, false
+	}
+	
+	// Unprotected routes share responses with everyone, so a response to a
+	// request with credentials must say it can be shared (RFC 9111, 3.5)
+	if authorized && !c.protected &&
+		!directives.Has("public") && !directives.Has("s-maxage") && !directives.Has("must-revalidate") {
+		return 0, false
+	}
+	
+	// Private responses are only cached for the user they belong to
+	if directives.Has("private") && !(c.protected && c.partition == config.CachePartitionUser) {
+		return 0, false
+	}
+	
+	if _, ok := responsecache.VaryHeaders(header); !ok {
+		return 0, false
+	}
+	
+	revalidatable := header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+	if directives.Has("no-cache") {
+		return 0, revalidatable
+	}
+	
+	lifetime, found := responsecache.Lifetime(header, directives)
+	if !found && status == http.StatusOK {
+		lifetime = c.ttl
+	}
+	return lifetime, lifetime > 0 || revalidatable
+}
+
+// responseCacheHandler serves GET requests from the response cache and
+// stores cacheable responses from next
+func (g *ApiGateway) responseCacheHandler(c *routeCache, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		
+		requestDirectives := responsecache.ParseCacheControl(r.Header.Values("Cache-Control"))
+		key, entry, ok := c.key(r)
+		if !ok || requestDirectives.Has("no-store") {
+			g.metrics.RecordResponseCacheLookup(c.route, cacheBypass)
+			next.ServeHTTP(w, r)
+			return
+		}
+		
+		now := time.Now()
+		cached, found := g.responseCache.Get(key, now)
+		if found && !cached.MatchesVary(r.Header) {
+			cached, found = nil, false
+		}
+		
+		// Clients can ask for a response from the upstream or limit its age
+		refresh := requestDirectives.Has("no-cache") || r.Header.Get("Pragma") == "no-cache"
+		if maxAge, limited := requestDirectives.Seconds("max-age"); limited && found {
+			refresh = refresh || cached.Age(now) > time.Duration(maxAge)*time.Second
+		}
+		
+		if found && !refresh && cached.Fresh(now) {
+			g.metrics.RecordResponseCacheLookup(c.route, cacheHit)
+			serveCachedResponse(w, r, cached, now, cacheHit)
+			return
+		}
+		
+		// Revalidate a stale response, unless the client sent conditions of its own
+		upstreamReq := r
+		authorized := hasCredentials(r, c.credentials)
+		writer := &cacheWriter{ResponseWriter: w, cache: c, authorized: authorized}
+		if found && r.Header.Get("If-None-Match") == "" && r.Header.Get("If-Modified-Since") == "" {
+			etag, lastModified := cached.Header.Get("ETag"), cached.Header.Get("Last-Modified")
+			if etag != "" || lastModified != "" {
+				upstreamReq = r.Clone(r.Context())
+				if etag != "" {
+					upstreamReq.Header.Set("If-None-Match", etag)
+				}
+				if lastModified != "" {
+					upstreamReq.Header.Set("If-Modified-Since", lastModified)
+				}
+				writer.revalidating = true
+			}
+		}
+		
+		next.ServeHTTP(writer, upstreamReq)
+		
+		// The stored response is still current; refresh its headers and serve it
+		if writer.notModified {
+			updated := *cached
+			updated.Header = cached.Header.Clone()
+			for _, name := range revalidatedHeaders {
+				if values := w.Header().Values(name); len(values) > 0 {
+					updated.Header[name] = values
+				}
+			}
+			updated.StoredAt = now
+			if lifetime, storable := c.lifetime(updated.Status, updated.Header, authorized); storable {
+				updated.FreshUntil = now.Add(lifetime)
+				g.storeResponse(key, &updated)
+			}
+			
+			g.metrics.RecordResponseCacheLookup(c.route, cacheRevalidated)
+			serveCachedResponse(w, r, &updated, now, cacheRevalidated)
+			return
+		}
+		
+		g.metrics.RecordResponseCacheLookup(c.route, cacheMiss)
+		if !writer.store {
+			return
+		}
+		
+		entry.Status = writer.status
+		entry.Header = w.Header().Clone()
+		entry.Header.Del("X-Cache")
+		entry.Body = writer.body.Bytes()
+		varyHeaders, _ := responsecache.VaryHeaders(entry.Header)
+		entry.Vary = responsecache.VaryValues(varyHeaders, r.Header)
+		entry.StoredAt = now
+		entry.FreshUntil = now.Add(writer.lifetime)
+		g.storeResponse(key, entry)
+	})
+}
+
+// storeResponse stores an entry, keeping it past its freshness if it can
+// be revalidated
+func (g *ApiGateway) storeResponse(key string, entry *responsecache.Entry) {
+	retainUntil := entry.FreshUntil
+	if entry.Header.Get("ETag") != "" || entry.Header.Get("Last-Modified") != "" {
+		retainUntil = retainUntil.Add(revalidationWindow)
+	}
+	
+	g.responseCache.Set(key, entry, retainUntil)
+	
+	stats := g.responseCache.Stats()
+	g.metrics.UpdateResponseCacheSize(stats.Entries, stats.Bytes)
+}
+
+// serveCachedResponse writes a stored response, or 304 if it satisfies the
+// client's conditions
+func serveCachedResponse(w http.ResponseWriter, r *http.Request, entry *responsecache.Entry, now time.Time, result string) {
+	header := w.Header()
+	for name := range header {
+		delete(header, name)
+	}
+	for name, values := range entry.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	header.Set("Age", strconv.Itoa(int(entry.Age(now).Seconds())))
+	header.Set("X-Cache", strings.ToUpper(result))
+	
+	if responsecache.NotModified(r.Header, entry.Header.Get("ETag"), entry.Header.Get("Last-Modified")) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	
+	w.WriteHeader(entry.Status)
+	w.Write(entry.Body)
+}
+
+// cacheWriter passes the upstream's response to the client while keeping
+// a copy of cacheable responses
+type cacheWriter struct {
+	http.ResponseWriter
+	cache        *routeCache
+	authorized   bool // The request carried credentials
+	revalidating bool // The gateway sent the conditions, so a 304 is for the gateway
+	status       int
+	notModified  bool
+	store        bool
+	lifetime     time.Duration
+	body         bytes.Buffer
+}
+
+func (w *cacheWriter) WriteHeader(status int) {
+	// Informational responses go straight to the client
+	if status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	
+	if w.revalidating && status == http.StatusNotModified {
+		w.notModified = true
+		return
+	}
+	
+	w.lifetime, w.store = w.cache.lifetime(status, w.Header(), w.authorized)
+	w.Header().Set("X-Cache", strings.ToUpper(cacheMiss))
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.notModified {
+		return len(p), nil
+	}
+	
+	// Stop copying once the response is too large to store
+	if w.store {
+		if int64(w.body.Len()+len(p)) > w.cache.maxEntryBytes {
+			w.store = false
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(p)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush keeps streamed responses flowing
+func (w *cacheWriter) Flush() {
+	if w.notModified {
+		return
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the client's writer
+func (w *cacheWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseCacheSeparatesCookieRequests(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/shared") {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		if session := r.Header.Get("Cookie"); session != "" {
+			w.Write([]byte(session))
+		} else {
+			w.Write([]byte("anonymous"))
+		}
+	}))
+	defer upstream.Close()
+
+	g := newTestGateway(t, `{
+		"store": {"type": "file", "file": {"path": "$STORE"}},
+		"routes": [{"pathPrefix": "/pub/", "targetUrl": "$UPSTREAM", "protected": false, "cache": {"enabled": true}}]
+	}`, upstream)
+
+	get := func(path, cookie, wantBody, wantCache string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		rec := serve(g, req)
+		if body := rec.Body.String(); body != wantBody {
+			t.Errorf("GET %s with cookie %q got %q, want %q", path, cookie, body, wantBody)
+		}
+		if cache := rec.Header().Get("X-Cache"); cache != wantCache {
+			t.Errorf("GET %s with cookie %q got X-Cache %q, want %q", path, cookie, cache, wantCache)
+		}
+	}
+
+	// A cached anonymous response isn't served to requests with a cookie,
+	// and responses to those aren't stored unless marked shareable
+	get("/pub/page", "", "anonymous", "MISS")
+	get("/pub/page", "", "anonymous", "HIT")
+	get("/pub/page", "session=alice", "session=alice", "MISS")
+	get("/pub/page", "session=alice", "session=alice", "MISS")
+	get("/pub/page", "session=bob", "session=bob", "MISS")
+
+	// A shareable response is only served to requests with the same cookie
+	get("/pub/shared", "session=alice", "session=alice", "MISS")
+	get("/pub/shared", "session=alice", "session=alice", "HIT")
+	get("/pub/shared", "session=bob", "session=bob", "MISS")
+	get("/pub/shared", "", "anonymous", "MISS")
+}
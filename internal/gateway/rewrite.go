@@ -0,0 +1,165 @@
+// Package gateway implements the core API gateway functionality
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"api-gateway/internal/config"
+)
+
+// rewriteRule is a compiled config.RewriteRule
+type rewriteRule struct {
+	match *regexp.Regexp
+	path  string      // Empty keeps the path
+	query [][2]string // Key and value templates of the query parameters to set
+}
+
+// newRewriteRules compiles a route's rewrite rules
+func newRewriteRules(rules []config.RewriteRule) ([]rewriteRule, error) {
+	compiled := make([]rewriteRule, 0, len(rules))
+	for i, rule := range rules {
+		if err := config.ValidateRewriteRule(rule); err != nil {
+			return nil, fmt.Errorf("rewrite[%d]: %w", i, err)
+		}
+		
+		r := rewriteRule{
+			match: regexp.MustCompile(rule.Match),
+			path:  rule.Path,
+		}
+		for _, param := range strings.Split(rule.Query, "&") {
+			if param == "" {
+				continue
+			}
+			key, value, _ := strings.Cut(param, "=")
+			r.query = append(r.query, [2]string{key, value})
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled, nil
+}
+
+// rewriteURL applies the first rule matching the path to u and reports
+// whether one matched. Query parameters set by the rule replace those of
+// the same name; other parameters of the request are kept.
+func rewriteURL(rules []rewriteRule, u *url.URL) bool {
+	for _, rule := range rules {
+		match := rule.match.FindStringSubmatch(u.Path)
+		if match == nil {
+			continue
+		}
+		
+		captures := make(map[string]string, len(match))
+		for i, name := range rule.match.SubexpNames() {
+			if name != "" {
+				captures[name] = match[i]
+			}
+		}
+		
+		if rule.path != "" {
+			u.Path = expandCaptures(rule.path, captures)
+			u.RawPath = ""
+		}
+		
+		if len(rule.query) > 0 {
+			query := u.Query()
+			for _, param := range rule.query {
+				query.Set(expandCaptures(param[0], captures), expandCaptures(param[1], captures))
+			}
+			u.RawQuery = query.Encode()
+		}
+		
+		return true
+	}
+	return false
+}
+
+// expandCaptures substitutes ${name} placeholders with captured values
+func expandCaptures(template string, captures map[string]string) string {
+	return headerTemplate.ReplaceAllStringFunc(template, func(placeholder string) string {
+		return captures[headerTemplate.FindStringSubmatch(placeholder)[1]]
+	})
+}
+
+// resolveRoute reports which route serves a request, whether a rewrite rule
+// matched, and the upstream URL the request would be proxied to
+func (g *ApiGateway) resolveRoute(method, host, rawPath string, header http.Header) (map[string]interface{}, error) {
+	u, err := url.Parse(rawPath)
+	if err != nil || !strings.HasPrefix(u.Path, "/") {
+		return nil, fmt.Errorf("path must be an absolute path, optionally with a query")
+	}
+	
+	matched := g.matchRoute(&http.Request{Method: method, Host: host, URL: u, Header: header})
+	if matched == nil {
+		return map[string]interface{}{"path": rawPath, "route": nil}, nil
+	}
+	route := &matched.route
+	
+	rules, err := newRewriteRules(route.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Mirror the route's Director: rewrite, join with the target, then strip
+	rewritten := rewriteURL(rules, u)
+	upstreamURL := func(targetURL string) (string, error) {
+		target, err := url.Parse(targetURL)
+		if err != nil {
+			return "", err
+		}
+		
+		upstream := *target
+		upstream.Path = strings.TrimSuffix(target.Path, "/") + u.Path
+		upstream.RawPath = ""
+		if route.StripPrefix && !rewritten {
+			upstream.Path = strings.TrimPrefix(upstream.Path, route.PathPrefix)
+			if !strings.HasPrefix(upstream.Path, "/") {
+				upstream.Path = "/" + upstream.Path
+			}
+		}
+		if target.RawQuery == "" || u.RawQuery == "" {
+			upstream.RawQuery = target.RawQuery + u.RawQuery
+		} else {
+			upstream.RawQuery = target.RawQuery + "&" + u.RawQuery
+		}
+		return upstream.String(), nil
+	}
+	
+	upstream, err := upstreamURL(route.TargetURL)
+	if err != nil {
+		return nil, err
+	}
+	resolved := map[string]interface{}{
+		"path":      rawPath,
+		"route":     route.PathPrefix,
+		"targetUrl": route.TargetURL,
+		"rewritten": rewritten,
+		"upstream":  upstream,
+	}
+	
+	// The variant depends on the caller, so list where each one would proxy to
+	if len(route.Split.Variants) > 0 {
+		variants := make([]map[string]interface{}, 0, len(route.Split.Variants))
+		for _, variant := range route.Split.Variants {
+			targetURL := variant.TargetURL
+			if targetURL == "" {
+				targetURL = route.TargetURL
+			}
+			upstream, err := upstreamURL(targetURL)
+			if err != nil {
+				return nil, err
+			}
+			variants = append(variants, map[string]interface{}{
+				"name":     variant.Name,
+				"weight":   variant.Weight,
+				"upstream": upstream,
+			})
+		}
+		resolved["variants"] = variants
+	}
+	
+	return resolved, nil
+}
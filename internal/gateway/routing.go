@@ -0,0 +1,46 @@
+// Package gateway implements the core API gateway functionality
+package gateway
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"api-gateway/internal/config"
+)
+
+// proxyRoute is a configured route with its handler
+type proxyRoute struct {
+	route   config.Route
+	handler http.Handler // Proxy, behind authentication if the route is protected
+}
+
+// matchRoute returns the route serving a request: the longest path prefix,
+// matched on segment boundaries, whose conditions match, the most specific
+// route first. Nil if none does.
+func (g *ApiGateway) matchRoute(r *http.Request) *proxyRoute {
+	for _, proxyRoute := range g.proxyRoutes {
+		if proxyRoute.route.MatchesPath(r.URL.Path) && proxyRoute.route.Matches(r) {
+			return proxyRoute
+		}
+	}
+	return nil
+}
+
+// dispatch returns a handler passing requests to their matching route, or
+// to notFound if the conditions of no route match
+func (g *ApiGateway) dispatch(notFound http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyRoute := g.matchRoute(r)
+		if proxyRoute == nil {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		
+		g.logger.Debug("Matched route",
+			zap.String("pathPrefix", proxyRoute.route.PathPrefix),
+			zap.Strings("hosts", proxyRoute.route.Hosts),
+			zap.Strings("methods", proxyRoute.route.Methods))
+		proxyRoute.handler.ServeHTTP(w, r)
+	})
+}
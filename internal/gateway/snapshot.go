@@ -0,0 +1,105 @@
+// Package gateway implements the core API gateway functionality
+package gateway
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"api-gateway/internal/snapshot"
+)
+
+// connect authenticates with the identity store if it needs a connection
+func (g *ApiGateway) connect() error {
+	if g.connectStore != nil {
+		if err := g.connectStore(g.background); err != nil {
+			return err
+		}
+	}
+	
+	g.storeConnected.Store(true)
+	return nil
+}
+
+// startDegraded loads the last snapshot into the cache after the identity
+// store failed with cause. It reports whether the gateway can run from it.
+func (g *ApiGateway) startDegraded(cause error) bool {
+	if g.snapshots == nil {
+		return false
+	}
+	
+	snap, err := g.snapshots.Load()
+	if errors.Is(err, snapshot.ErrNotFound) {
+		g.logger.Warn("No snapshot available to start in degraded mode")
+		return false
+	}
+	if err != nil {
+		g.logger.Error("Failed to load snapshot", zap.Error(err))
+		return false
+	}
+	
+	// An old snapshot may grant access that has since been revoked
+	age := time.Since(snap.CreatedAt)
+	if g.snapshotMaxAge > 0 && age > g.snapshotMaxAge {
+		g.logger.Error("Snapshot is too old to start in degraded mode",
+			zap.Time("created_at", snap.CreatedAt),
+			zap.Duration("max_age", g.snapshotMaxAge))
+		return false
+	}
+	
+	// Restore roles and the tokens that were valid when the snapshot was taken
+	if err := g.cache.BulkLoadRoles(snap.Roles); err != nil {
+		g.logger.Error("Problems detected in role hierarchy", zap.Error(err))
+	}
+	restored := g.cache.RestoreTokens(snap.Tokens)
+	
+	g.degradedSince.Store(time.Now().UnixNano())
+	g.metrics.SetDegraded(true)
+	
+	stats := g.cache.GetStats()
+	g.metrics.UpdateCacheSize(stats["users"], stats["roles"])
+	
+	g.logger.Warn("Identity store unavailable, starting in degraded mode from snapshot",
+		zap.Error(cause),
+		zap.Time("snapshot_created_at", snap.CreatedAt),
+		zap.Int("roles", len(snap.Roles)),
+		zap.Int("tokens", restored))
+	
+	return true
+}
+
+// leaveDegraded ends degraded mode, if active
+func (g *ApiGateway) leaveDegraded() {
+	since := g.degradedSince.Swap(0)
+	if since == 0 {
+		return
+	}
+	
+	g.metrics.SetDegraded(false)
+	g.logger.Info("Identity store recovered, leaving degraded mode",
+		zap.Duration("degraded_for", time.Since(time.Unix(0, since))))
+}
+
+// degradedAt returns when degraded mode started, or the zero time if not degraded
+func (g *ApiGateway) degradedAt() time.Time {
+	since := g.degradedSince.Load()
+	if since == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, since)
+}
+
+// saveSnapshot persists the cached roles and tokens; failures are only logged
+func (g *ApiGateway) saveSnapshot() {
+	if g.snapshots == nil {
+		return
+	}
+	
+	if err := g.snapshots.Save(g.cache.Roles(), g.cache.Tokens()); err != nil {
+		g.logger.Warn("Failed to save snapshot", zap.Error(err))
+		return
+	}
+	
+	g.logger.Debug("Saved snapshot")
+}
@@ -0,0 +1,189 @@
+// Package gateway implements the core API gateway functionality
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"net/url"
+
+	"go.uber.org/zap"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/pocketbase"
+)
+
+// Reasons a variant was chosen, as reported in logs and metrics
+const (
+	variantByOverride = "override"
+	variantByUser     = "user"
+	variantByRole     = "role"
+	variantByWeight   = "weight"
+)
+
+// splitVariant is a variant of a route with its proxy
+type splitVariant struct {
+	name    string
+	weight  int
+	handler http.Handler
+}
+
+// trafficSplit chooses the variant serving each request on a route
+type trafficSplit struct {
+	route       string                   // Path prefix, for logs and metrics
+	variants    []*splitVariant          // In configuration order
+	byName      map[string]*splitVariant // Variant name -> variant
+	users       map[string]*splitVariant // User ID -> pinned variant
+	roles       map[string]*splitVariant // Role name -> pinned variant
+	totalWeight int
+	header      string
+	cookie      string
+}
+
+// newTrafficSplit creates a proxy for each of the route's variants
+func (g *ApiGateway) newTrafficSplit(route config.Route, targetURL *url.URL, headers routeHeaderRules) (*trafficSplit, error) {
+	split := &trafficSplit{
+		route:  route.PathPrefix,
+		byName: make(map[string]*splitVariant),
+		users:  make(map[string]*splitVariant),
+		roles:  make(map[string]*splitVariant),
+		header: route.Split.Header,
+		cookie: route.Split.Cookie,
+	}
+	
+	for _, v := range route.Split.Variants {
+		variantURL := targetURL
+		if v.TargetURL != "" {
+			parsed, err := url.Parse(v.TargetURL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target URL %s of variant %s: %w", v.TargetURL, v.Name, err)
+			}
+			variantURL = parsed
+		}
+		
+		handler, err := g.newRouteProxy(route, variantURL, headers)
+		if err != nil {
+			return nil, err
+		}
+		
+		variant := &splitVariant{name: v.Name, weight: v.Weight, handler: handler}
+		split.variants = append(split.variants, variant)
+		split.byName[v.Name] = variant
+		for _, userID := range v.Users {
+			split.users[userID] = variant
+		}
+		for _, roleName := range v.Roles {
+			split.roles[roleName] = variant
+		}
+		split.totalWeight += v.Weight
+		
+		g.logger.Info("Set up route variant",
+			zap.String("pathPrefix", route.PathPrefix),
+			zap.String("variant", v.Name),
+			zap.String("targetURL", variantURL.String()),
+			zap.Int("weight", v.Weight))
+	}
+	
+	return split, nil
+}
+
+// choose picks the variant for a request and reports why
+func (s *trafficSplit) choose(r *http.Request) (*splitVariant, string) {
+	// Testers opt in with the override header or cookie; unknown names are ignored
+	if s.header != "" {
+		if variant, found := s.byName[r.Header.Get(s.header)]; found {
+			return variant, variantByOverride
+		}
+	}
+	if s.cookie != "" {
+		if cookie, err := r.Cookie(s.cookie); err == nil {
+			if variant, found := s.byName[cookie.Value]; found {
+				return variant, variantByOverride
+			}
+		}
+	}
+	
+	// Pinned users, then pinned roles in the order the user holds them
+	user, hasUser := r.Context().Value("user").(*pocketbase.User)
+	if hasUser {
+		if variant, found := s.users[user.ID]; found {
+			return variant, variantByUser
+		}
+	}
+	if roles, ok := r.Context().Value("roles").([]*pocketbase.Role); ok {
+		for _, role := range roles {
+			if variant, found := s.roles[role.Name]; found {
+				return variant, variantByRole
+			}
+		}
+	}
+	
+	// Weighted assignment, sticky per user so a user doesn't switch between versions
+	var point int
+	if hasUser {
+		hash := fnv.New32a()
+		hash.Write([]byte(user.ID))
+		point = int(hash.Sum32() % uint32(s.totalWeight))
+	} else {
+		point = rand.Intn(s.totalWeight)
+	}
+	for _, variant := range s.variants {
+		if point < variant.weight {
+			return variant, variantByWeight
+		}
+		point -= variant.weight
+	}
+	
+	// Unreachable while the weights add up to totalWeight
+	return s.variants[0], variantByWeight
+}
+
+// chooseVariant picks the variant of each request and passes it on in the
+// request context. It runs before the response cache and coalescing, so
+// that they keep the responses of different variants apart.
+func (g *ApiGateway) chooseVariant(split *trafficSplit, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		variant, reason := split.choose(r)
+		
+		// Report the variant in the request log
+		if logged, ok := r.Context().Value("routeVariant").(*string); ok {
+			*logged = variant.name
+		}
+		g.metrics.RecordRouteVariant(split.route, variant.name, reason)
+		g.logger.Debug("Selected route variant",
+			zap.String("pathPrefix", split.route),
+			zap.String("variant", variant.name),
+			zap.String("reason", reason))
+		
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "splitVariant", variant)))
+	})
+}
+
+// splitHandler passes each request to the proxy of the variant chosen for it
+func (g *ApiGateway) splitHandler(split *trafficSplit) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		variant, ok := r.Context().Value("splitVariant").(*splitVariant)
+		if !ok {
+			variant, _ = split.choose(r)
+		}
+		variant.handler.ServeHTTP(w, r)
+	})
+}
+
+// requestVariant returns the name of the variant chosen for a request, or
+// an empty string if its route doesn't split traffic
+func requestVariant(r *http.Request) string {
+	if variant, ok := r.Context().Value("splitVariant").(*splitVariant); ok {
+		return variant.name
+	}
+	return ""
+}
+
+// withVariantLogging lets the split handler report the chosen variant to
+// the request log
+func withVariantLogging(r *http.Request) (*http.Request, *string) {
+	variant := new(string)
+	return r.WithContext(context.WithValue(r.Context(), "routeVariant", variant)), variant
+}
@@ -0,0 +1,119 @@
+// Package gateway implements the core API gateway functionality
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/pocketbase"
+	"api-gateway/internal/store"
+)
+
+// newStore creates the configured identity store. The PocketBase client is
+// returned separately, as realtime updates are only available with PocketBase;
+// it is nil for other stores. The returned connect function authenticates
+// with the store and is nil if the store needs no connection.
+func newStore(cfg *config.Config, logger *zap.Logger) (store.UserRoleStore, *pocketbase.Client, func(context.Context) error, error) {
+	storeLogger := logger.With(zap.String("component", "store"))
+	
+	switch cfg.Store.Type {
+	case store.TypeFile:
+		fileStore, err := store.NewFileStore(cfg.Store.File.Path, storeLogger)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to load file store: %w", err)
+		}
+		return fileStore, nil, nil, nil
+		
+	case store.TypeSQLite:
+		sqliteStore, err := store.NewSQLiteStore(cfg.Store.SQLite.Path, storeLogger)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to open SQLite store: %w", err)
+		}
+		return sqliteStore, nil, nil, nil
+	}
+	
+	// Initialize the PocketBase client
+	pbClient := pocketbase.NewClient(
+		cfg.PocketBase.URL,
+		cfg.PocketBase.UserCollection,
+		cfg.PocketBase.RoleCollection,
+		pocketbase.Options{
+			UserList: pocketbase.ListOptions{
+				PerPage:   cfg.PocketBase.PerPage,
+				Filter:    cfg.PocketBase.UserFilter,
+				Fields:    cfg.PocketBase.UserFields,
+				SkipTotal: cfg.PocketBase.SkipTotal,
+			},
+			RoleList: pocketbase.ListOptions{
+				PerPage:   cfg.PocketBase.PerPage,
+				Filter:    cfg.PocketBase.RoleFilter,
+				Fields:    cfg.PocketBase.RoleFields,
+				SkipTotal: cfg.PocketBase.SkipTotal,
+			},
+			Transport: pocketbase.TransportOptions{
+				DialTimeout:           time.Duration(cfg.PocketBase.Transport.DialTimeoutSeconds) * time.Second,
+				KeepAlive:             time.Duration(cfg.PocketBase.Transport.KeepAliveSeconds) * time.Second,
+				MaxIdleConns:          cfg.PocketBase.Transport.MaxIdleConns,
+				MaxIdleConnsPerHost:   cfg.PocketBase.Transport.MaxIdleConnsPerHost,
+				MaxConnsPerHost:       cfg.PocketBase.Transport.MaxConnsPerHost,
+				IdleConnTimeout:       time.Duration(cfg.PocketBase.Transport.IdleConnTimeoutSeconds) * time.Second,
+				TLSHandshakeTimeout:   time.Duration(cfg.PocketBase.Transport.TLSHandshakeTimeoutSeconds) * time.Second,
+				ResponseHeaderTimeout: time.Duration(cfg.PocketBase.Transport.ResponseHeaderTimeoutSeconds) * time.Second,
+				RequestTimeout:        time.Duration(cfg.PocketBase.Transport.RequestTimeoutSeconds) * time.Second,
+			},
+			Retry: pocketbase.RetryOptions{
+				MaxAttempts:    cfg.PocketBase.Retry.MaxAttempts,
+				InitialBackoff: time.Duration(cfg.PocketBase.Retry.InitialBackoffMs) * time.Millisecond,
+				MaxBackoff:     time.Duration(cfg.PocketBase.Retry.MaxBackoffMs) * time.Millisecond,
+			},
+			Fields: fieldMapping(cfg),
+		},
+		logger.With(zap.String("component", "pocketbase")),
+	)
+	
+	// Authenticate with PocketBase, preferring a pre-issued API token
+	connect := func(ctx context.Context) error {
+		if cfg.PocketBase.APIToken != "" {
+			if err := pbClient.UseAPIToken(cfg.PocketBase.APIToken); err != nil {
+				return fmt.Errorf("failed to authenticate with PocketBase: %w", err)
+			}
+		} else if err := pbClient.AuthenticateContext(ctx, cfg.PocketBase.ServiceAccount, cfg.PocketBase.ServicePassword); err != nil {
+			return fmt.Errorf("failed to authenticate with PocketBase: %w", err)
+		}
+		return nil
+	}
+	
+	return pbClient, pbClient, connect, nil
+}
+
+// fieldMapping builds the PocketBase field mapping from the configuration
+func fieldMapping(cfg *config.Config) pocketbase.FieldMapping {
+	mapping := cfg.PocketBase.FieldMapping
+	
+	extra := make([]string, 0, len(mapping.User.Extra))
+	for _, field := range mapping.User.Extra {
+		extra = append(extra, field.Field)
+	}
+	
+	return pocketbase.FieldMapping{
+		User: pocketbase.UserFieldMapping{
+			Username: mapping.User.Username,
+			Email:    mapping.User.Email,
+			RoleID:   mapping.User.RoleID,
+			RoleIDs:  mapping.User.RoleIDs,
+			Active:   mapping.User.Active,
+			Extra:    extra,
+		},
+		Role: pocketbase.RoleFieldMapping{
+			Name:                 mapping.Role.Name,
+			Parent:               mapping.Role.Parent,
+			PublishPermissions:   mapping.Role.PublishPermissions,
+			SubscribePermissions: mapping.Role.SubscribePermissions,
+		},
+		ExpandRoles: mapping.ExpandRoles,
+	}
+}
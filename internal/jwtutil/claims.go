@@ -0,0 +1,74 @@
+// Package jwtutil reads claims from JWTs without verifying their signature.
+// It is only meant for tokens that are verified elsewhere (for example by
+// PocketBase), to learn metadata such as the expiry time.
+package jwtutil
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Claims holds the registered and PocketBase-specific claims the gateway uses
+type Claims struct {
+	// ID is the JWT ID ("jti")
+	ID string `json:"jti,omitempty"`
+	// Subject is the "sub" claim
+	Subject string `json:"sub,omitempty"`
+	// RecordID is the PocketBase record ID ("id")
+	RecordID string `json:"id,omitempty"`
+	// CollectionID is the ID of the PocketBase collection holding the record ("collectionId")
+	CollectionID string `json:"collectionId,omitempty"`
+	// Type is the PocketBase token type, e.g. "auth"
+	Type string `json:"type,omitempty"`
+	// IssuedAt is the "iat" claim in Unix seconds
+	IssuedAt int64 `json:"iat,omitempty"`
+	// ExpiresAt is the "exp" claim in Unix seconds
+	ExpiresAt int64 `json:"exp,omitempty"`
+}
+
+// ParseUnverified decodes the payload of a compact JWT without checking the signature
+func ParseUnverified(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token: expected 3 parts, got %d", len(parts))
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("malformed token payload: %w", err)
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+
+	return &claims, nil
+}
+
+// Expiry returns the expiry time, or the zero time if the token has no "exp" claim
+func (c *Claims) Expiry() time.Time {
+	if c.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// IssuedAtTime returns the issue time, or the zero time if the token has no "iat" claim
+func (c *Claims) IssuedAtTime() time.Time {
+	if c.IssuedAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// UserID returns the PocketBase record ID, falling back to the subject
+func (c *Claims) UserID() string {
+	if c.RecordID != "" {
+		return c.RecordID
+	}
+	return c.Subject
+}
@@ -2,18 +2,34 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 // Metrics contains all Prometheus metrics for the API gateway
 type Metrics struct {
-	RequestsTotal      *prometheus.CounterVec
-	RequestDuration    *prometheus.HistogramVec
-	AuthFailures       *prometheus.CounterVec
-	CacheRefreshes     prometheus.Counter
-	CacheSize          *prometheus.GaugeVec
-	ActiveConnections  prometheus.Gauge
+	RequestsTotal        *prometheus.CounterVec
+	RequestDuration      *prometheus.HistogramVec
+	AuthFailures         *prometheus.CounterVec
+	CacheRefreshes       prometheus.Counter
+	CacheRefreshFailures prometheus.Counter
+	CacheLastRefresh     prometheus.Gauge
+	CacheStaleness       prometheus.Gauge
+	CacheSize            *prometheus.GaugeVec
+	TokenLookups         *prometheus.CounterVec
+	RealtimeConnected    prometheus.Gauge
+	RealtimeEvents       *prometheus.CounterVec
+	Degraded             prometheus.Gauge
+	ActiveConnections    prometheus.Gauge
+	PathRejections       *prometheus.CounterVec
+	RouteVariants        *prometheus.CounterVec
+	MirrorRequests       *prometheus.CounterVec
+	ResponseCacheLookups *prometheus.CounterVec
+	ResponseCacheSize    *prometheus.GaugeVec
+	CoalescedRequests    *prometheus.CounterVec
+	LimitRejections      *prometheus.CounterVec
 }
 
 // NewMetrics creates and registers all metrics
@@ -55,6 +71,30 @@ func NewMetrics(namespace string) *Metrics {
 			},
 		),
 		
+		CacheRefreshFailures: promauto.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "cache_refresh_failures_total",
+				Help:      "Total number of failed cache refresh operations",
+			},
+		),
+		
+		CacheLastRefresh: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "cache_last_refresh_timestamp_seconds",
+				Help:      "Unix time of the last successful cache refresh",
+			},
+		),
+		
+		CacheStaleness: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "cache_staleness_seconds",
+				Help:      "Seconds since the last successful cache refresh",
+			},
+		),
+		
 		CacheSize: promauto.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Namespace: namespace,
@@ -64,6 +104,40 @@ func NewMetrics(namespace string) *Metrics {
 			[]string{"type"},
 		),
 		
+		TokenLookups: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "token_lookups_total",
+				Help:      "Total number of token lookups by result (hit, miss, negative_hit, coalesced)",
+			},
+			[]string{"result"},
+		),
+		
+		RealtimeConnected: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "realtime_connected",
+				Help:      "Whether the PocketBase realtime subscription is connected (1) or not (0)",
+			},
+		),
+		
+		RealtimeEvents: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "realtime_events_total",
+				Help:      "Total number of PocketBase realtime events applied to the cache",
+			},
+			[]string{"collection", "action"},
+		),
+		
+		Degraded: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "degraded",
+				Help:      "Whether the gateway is serving from a snapshot because the identity store is unavailable (1) or not (0)",
+			},
+		),
+		
 		ActiveConnections: promauto.NewGauge(
 			prometheus.GaugeOpts{  // Changed from CounterOpts to GaugeOpts
 				Namespace: namespace,
@@ -71,6 +145,69 @@ func NewMetrics(namespace string) *Metrics {
 				Help:      "Number of active connections",
 			},
 		),
+		
+		PathRejections: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "path_rejections_total",
+				Help:      "Total number of requests rejected because of an ambiguous path",
+			},
+			[]string{"reason"},
+		),
+		
+		RouteVariants: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "route_variant_requests_total",
+				Help:      "Total number of requests sent to each variant of a split route, by why the variant was chosen (override, user, role, weight)",
+			},
+			[]string{"route", "variant", "reason"},
+		),
+		
+		MirrorRequests: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "mirror_requests_total",
+				Help:      "Total number of sampled requests on mirrored routes by result (match, status_mismatch, body_mismatch, error, dropped, too_large)",
+			},
+			[]string{"route", "result"},
+		),
+		
+		ResponseCacheLookups: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "response_cache_requests_total",
+				Help:      "Total number of GET requests on caching routes by result (hit, revalidated, miss, bypass)",
+			},
+			[]string{"route", "result"},
+		),
+		
+		ResponseCacheSize: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "response_cache_size",
+				Help:      "Number of cached responses (entries) and their size in bytes (bytes)",
+			},
+			[]string{"type"},
+		),
+		
+		CoalescedRequests: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "coalesced_requests_total",
+				Help:      "Total number of GET requests on coalescing routes that had identical requests in flight, by outcome (leader, shared, fallback)",
+			},
+			[]string{"route", "result"},
+		),
+		
+		LimitRejections: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "request_limit_rejections_total",
+				Help:      "Total number of requests rejected for exceeding a size limit, by limit (body, headers, url)",
+			},
+			[]string{"limit"},
+		),
 	}
 }
 
@@ -94,12 +231,55 @@ func (m *Metrics) RecordCacheRefresh() {
 	m.CacheRefreshes.Inc()
 }
 
+// RecordCacheRefreshFailure increments the failed cache refresh counter
+func (m *Metrics) RecordCacheRefreshFailure() {
+	m.CacheRefreshFailures.Inc()
+}
+
+// SetCacheLastRefresh records the time of the last successful cache refresh
+func (m *Metrics) SetCacheLastRefresh(t time.Time) {
+	m.CacheLastRefresh.Set(float64(t.Unix()))
+}
+
+// SetCacheStaleness records how long ago the cache was last refreshed
+func (m *Metrics) SetCacheStaleness(age time.Duration) {
+	m.CacheStaleness.Set(age.Seconds())
+}
+
 // UpdateCacheSize updates the cache size metrics
 func (m *Metrics) UpdateCacheSize(users, roles int) {
 	m.CacheSize.WithLabelValues("users").Set(float64(users))
 	m.CacheSize.WithLabelValues("roles").Set(float64(roles))
 }
 
+// RecordTokenLookup increments the token lookup counter with the given result
+func (m *Metrics) RecordTokenLookup(result string) {
+	m.TokenLookups.WithLabelValues(result).Inc()
+}
+
+// SetRealtimeConnected records whether the realtime subscription is connected
+func (m *Metrics) SetRealtimeConnected(connected bool) {
+	if connected {
+		m.RealtimeConnected.Set(1)
+	} else {
+		m.RealtimeConnected.Set(0)
+	}
+}
+
+// RecordRealtimeEvent increments the realtime event counter
+func (m *Metrics) RecordRealtimeEvent(collection, action string) {
+	m.RealtimeEvents.WithLabelValues(collection, action).Inc()
+}
+
+// SetDegraded records whether the gateway is running in degraded mode
+func (m *Metrics) SetDegraded(degraded bool) {
+	if degraded {
+		m.Degraded.Set(1)
+	} else {
+		m.Degraded.Set(0)
+	}
+}
+
 // IncActiveConnections increments the active connections counter
 func (m *Metrics) IncActiveConnections() {
 	m.ActiveConnections.Inc()
@@ -109,3 +289,39 @@ func (m *Metrics) IncActiveConnections() {
 func (m *Metrics) DecActiveConnections() {
 	m.ActiveConnections.Dec()
 }
+
+// RecordPathRejection increments the path rejection counter with the given reason
+func (m *Metrics) RecordPathRejection(reason string) {
+	m.PathRejections.WithLabelValues(reason).Inc()
+}
+
+// RecordRouteVariant increments the counter of requests sent to a route variant
+func (m *Metrics) RecordRouteVariant(route, variant, reason string) {
+	m.RouteVariants.WithLabelValues(route, variant, reason).Inc()
+}
+
+// RecordMirror increments the mirrored request counter with the given result
+func (m *Metrics) RecordMirror(route, result string) {
+	m.MirrorRequests.WithLabelValues(route, result).Inc()
+}
+
+// RecordResponseCacheLookup increments the response cache counter with the given result
+func (m *Metrics) RecordResponseCacheLookup(route, result string) {
+	m.ResponseCacheLookups.WithLabelValues(route, result).Inc()
+}
+
+// UpdateResponseCacheSize updates the response cache size metrics
+func (m *Metrics) UpdateResponseCacheSize(entries int, bytes int64) {
+	m.ResponseCacheSize.WithLabelValues("entries").Set(float64(entries))
+	m.ResponseCacheSize.WithLabelValues("bytes").Set(float64(bytes))
+}
+
+// RecordCoalescedRequest increments the coalesced request counter with the given outcome
+func (m *Metrics) RecordCoalescedRequest(route, result string) {
+	m.CoalescedRequests.WithLabelValues(route, result).Inc()
+}
+
+// RecordRequestLimitRejection increments the counter of requests rejected for exceeding the given limit
+func (m *Metrics) RecordRequestLimitRejection(limit string) {
+	m.LimitRejections.WithLabelValues(limit).Inc()
+}
@@ -0,0 +1,188 @@
+// Package pathnorm canonicalizes request paths before they are checked
+// against permissions and forwarded to backends, so that the path the
+// permission matcher sees is exactly the path the backend receives.
+package pathnorm
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Policy controls how ambiguous path constructs are handled
+type Policy int
+
+const (
+	// Normalize rewrites ambiguous constructs into their canonical form
+	Normalize Policy = iota
+	// Reject refuses any path that is not already canonical
+	Reject
+)
+
+// ParsePolicy converts a configuration string into a Policy
+func ParsePolicy(s string) (Policy, error) {
+	switch strings.ToLower(s) {
+	case "", "normalize":
+		return Normalize, nil
+	case "reject":
+		return Reject, nil
+	default:
+		return Normalize, fmt.Errorf("unknown path normalization policy %q", s)
+	}
+}
+
+// String returns the configuration name of the policy
+func (p Policy) String() string {
+	if p == Reject {
+		return "reject"
+	}
+	return "normalize"
+}
+
+// Options configures path canonicalization
+type Options struct {
+	// Policy applies to dot segments, duplicate slashes, backslashes and trailing dots
+	Policy Policy
+
+	// DecodeEncodedSlashes turns %2F into a path separator instead of rejecting it
+	DecodeEncodedSlashes bool
+}
+
+// Error describes why a path was rejected
+type Error struct {
+	// Reason is a short, stable identifier suitable for metrics labels
+	Reason string
+	// Path is the offending raw path
+	Path string
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return fmt.Sprintf("ambiguous request path (%s): %q", e.Reason, e.Path)
+}
+
+// ErrAmbiguousPath is matched by every *Error via errors.Is
+var ErrAmbiguousPath = errors.New("ambiguous request path")
+
+// Is reports whether target is ErrAmbiguousPath
+func (e *Error) Is(target error) bool {
+	return target == ErrAmbiguousPath
+}
+
+// Reasons reported in Error.Reason
+const (
+	ReasonControlChar    = "control_character"
+	ReasonEncodedSlash   = "encoded_slash"
+	ReasonDoubleEncoding = "double_encoding"
+	ReasonNotAbsolute    = "not_absolute"
+	ReasonBackslash      = "backslash"
+	ReasonDuplicateSlash = "duplicate_slash"
+	ReasonDotSegment     = "dot_segment"
+	ReasonTrailingDot    = "trailing_dot"
+)
+
+// Canonicalize returns the canonical, decoded form of the URL's path.
+// Constructs that are always ambiguous (control characters, double encoding,
+// encoded slashes unless decoding is enabled) are rejected regardless of policy.
+// The returned path has no dot segments, no empty segments and no segments
+// ending in a dot, and preserves a single trailing slash if one was present.
+func Canonicalize(u *url.URL, opts Options) (string, error) {
+	raw := u.EscapedPath()
+	decoded := u.Path
+
+	// RawPath is only populated when the client used a non-default encoding,
+	// which is the only way an encoded separator can reach us
+	original := u.RawPath
+	if original == "" {
+		original = decoded
+	}
+
+	// Control characters (including NUL) are never legitimate in a path
+	for i := 0; i < len(decoded); i++ {
+		if decoded[i] < 0x20 || decoded[i] == 0x7f {
+			return "", &Error{Reason: ReasonControlChar, Path: raw}
+		}
+	}
+
+	// A decoded path that still contains escapes for separators, dots or the
+	// escape character itself would be decoded a second time by some backends
+	lowerDecoded := strings.ToLower(decoded)
+	for _, seq := range []string{"%2f", "%5c", "%2e", "%25"} {
+		if strings.Contains(lowerDecoded, seq) {
+			return "", &Error{Reason: ReasonDoubleEncoding, Path: raw}
+		}
+	}
+
+	// Encoded separators are split differently by different backends. A lone
+	// %5C is indistinguishable from a literal backslash once parsed, since both
+	// escape the same way, and is handled as a backslash below.
+	lowerOriginal := strings.ToLower(original)
+	if strings.Contains(lowerOriginal, "%2f") || strings.Contains(lowerOriginal, "%5c") {
+		if !opts.DecodeEncodedSlashes {
+			return "", &Error{Reason: ReasonEncodedSlash, Path: raw}
+		}
+	}
+
+	if !strings.HasPrefix(decoded, "/") {
+		return "", &Error{Reason: ReasonNotAbsolute, Path: raw}
+	}
+
+	// Backslashes are treated as separators by some servers
+	if strings.Contains(decoded, "\\") {
+		if opts.Policy == Reject {
+			return "", &Error{Reason: ReasonBackslash, Path: raw}
+		}
+		decoded = strings.ReplaceAll(decoded, "\\", "/")
+	}
+
+	if decoded == "/" {
+		return decoded, nil
+	}
+
+	trailingSlash := len(decoded) > 1 && strings.HasSuffix(decoded, "/")
+
+	segments := strings.Split(strings.TrimPrefix(decoded, "/"), "/")
+	if trailingSlash {
+		segments = segments[:len(segments)-1]
+	}
+
+	canonical := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		switch {
+		case segment == "":
+			if opts.Policy == Reject {
+				return "", &Error{Reason: ReasonDuplicateSlash, Path: raw}
+			}
+		case segment == ".":
+			if opts.Policy == Reject {
+				return "", &Error{Reason: ReasonDotSegment, Path: raw}
+			}
+		case segment == "..":
+			if opts.Policy == Reject {
+				return "", &Error{Reason: ReasonDotSegment, Path: raw}
+			}
+			// Never climb above the root
+			if len(canonical) > 0 {
+				canonical = canonical[:len(canonical)-1]
+			}
+		case strings.HasSuffix(segment, "."):
+			// Trailing dots are silently stripped by some filesystems and servers
+			if opts.Policy == Reject {
+				return "", &Error{Reason: ReasonTrailingDot, Path: raw}
+			}
+			if trimmed := strings.TrimRight(segment, "."); trimmed != "" {
+				canonical = append(canonical, trimmed)
+			}
+		default:
+			canonical = append(canonical, segment)
+		}
+	}
+
+	result := "/" + strings.Join(canonical, "/")
+	if trailingSlash && result != "/" {
+		result += "/"
+	}
+
+	return result, nil
+}
@@ -0,0 +1,166 @@
+package pathnorm
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// parse builds the URL of a request line's target, as net/http does
+func parse(t testing.TB, target string) *url.URL {
+	t.Helper()
+	u, err := url.ParseRequestURI(target)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", target, err)
+	}
+	return u
+}
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		opts   Options
+		want   string
+		reason string // Expected rejection reason, empty if accepted
+	}{
+		{name: "root", target: "/", want: "/"},
+		{name: "canonical", target: "/api/v1/devices", want: "/api/v1/devices"},
+		{name: "trailing slash kept", target: "/api/v1/", want: "/api/v1/"},
+		{name: "duplicate slashes", target: "/api//v1///devices", want: "/api/v1/devices"},
+		{name: "dot segments", target: "/api/./v1/../v2/devices", want: "/api/v2/devices"},
+		{name: "climb above root", target: "/../../etc/passwd", want: "/etc/passwd"},
+		{name: "trailing dots", target: "/api./v1../devices", want: "/api/v1/devices"},
+		{name: "only dots", target: "/api/.../x", want: "/api/x"},
+		{name: "backslash", target: "/api\\..\\admin", want: "/admin"},
+		{name: "encoded dot segment", target: "/api/%2e%2e/admin", want: "/admin"},
+		{name: "mixed case encoded dots", target: "/api/%2E./admin", want: "/admin"},
+		{name: "encoded slash rejected", target: "/api%2F..%2Fadmin", reason: ReasonEncodedSlash},
+		{name: "encoded lowercase slash rejected", target: "/api%2f..%2fadmin", reason: ReasonEncodedSlash},
+		{name: "encoded backslash", target: "/api%5C..%5Cadmin", want: "/admin"},
+		{name: "encoded backslash with encoded slash", target: "/api%5Cadmin%2F", reason: ReasonEncodedSlash},
+		{name: "encoded slash decoded", target: "/api%2F..%2Fadmin", opts: Options{DecodeEncodedSlashes: true}, want: "/admin"},
+		{name: "encoded slash decoded in segment", target: "/api/a%2Fb", opts: Options{DecodeEncodedSlashes: true}, want: "/api/a/b"},
+		{name: "double encoded slash", target: "/api%252F..%252Fadmin", reason: ReasonDoubleEncoding},
+		{name: "double encoded slash with decoding", target: "/api%252Fadmin", opts: Options{DecodeEncodedSlashes: true}, reason: ReasonDoubleEncoding},
+		{name: "double encoded dot", target: "/api/%252e%252e/admin", reason: ReasonDoubleEncoding},
+		{name: "encoded percent", target: "/api/%2525", reason: ReasonDoubleEncoding},
+		{name: "encoded NUL", target: "/api/%00/admin", reason: ReasonControlChar},
+		{name: "encoded newline", target: "/api%0A", reason: ReasonControlChar},
+
+		{name: "reject canonical", target: "/api/v1/", opts: Options{Policy: Reject}, want: "/api/v1/"},
+		{name: "reject root", target: "/", opts: Options{Policy: Reject}, want: "/"},
+		{name: "reject duplicate slash", target: "/api//v1", opts: Options{Policy: Reject}, reason: ReasonDuplicateSlash},
+		{name: "reject dot", target: "/api/./v1", opts: Options{Policy: Reject}, reason: ReasonDotSegment},
+		{name: "reject dot dot", target: "/api/../v1", opts: Options{Policy: Reject}, reason: ReasonDotSegment},
+		{name: "reject encoded dot dot", target: "/api/%2e%2e/v1", opts: Options{Policy: Reject}, reason: ReasonDotSegment},
+		{name: "reject trailing dot", target: "/api./v1", opts: Options{Policy: Reject}, reason: ReasonTrailingDot},
+		{name: "reject backslash", target: "/api\\v1", opts: Options{Policy: Reject}, reason: ReasonBackslash},
+		{name: "reject encoded backslash", target: "/api%5Cv1", opts: Options{Policy: Reject}, reason: ReasonBackslash},
+		{name: "reject encoded slash", target: "/api%2Fv1", opts: Options{Policy: Reject}, reason: ReasonEncodedSlash},
+		{name: "reject decoded slash", target: "/api%2Fv1", opts: Options{Policy: Reject, DecodeEncodedSlashes: true}, want: "/api/v1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Canonicalize(parse(t, tt.target), tt.opts)
+			if tt.reason != "" {
+				var pathErr *Error
+				if !errors.As(err, &pathErr) {
+					t.Fatalf("Canonicalize(%q) = %q, %v; want rejection %q", tt.target, got, err, tt.reason)
+				}
+				if pathErr.Reason != tt.reason {
+					t.Errorf("Canonicalize(%q) rejected with %q, want %q", tt.target, pathErr.Reason, tt.reason)
+				}
+				if !errors.Is(err, ErrAmbiguousPath) {
+					t.Errorf("Canonicalize(%q) error does not match ErrAmbiguousPath", tt.target)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Canonicalize(%q) failed: %v", tt.target, err)
+			}
+			if got != tt.want {
+				t.Errorf("Canonicalize(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for input, want := range map[string]Policy{"": Normalize, "normalize": Normalize, "REJECT": Reject} {
+		got, err := ParsePolicy(input)
+		if err != nil || got != want {
+			t.Errorf("ParsePolicy(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+	if _, err := ParsePolicy("lenient"); err == nil {
+		t.Error("ParsePolicy accepted an unknown policy")
+	}
+}
+
+// checkCanonical fails unless path is in canonical form
+func checkCanonical(t *testing.T, input, path string) {
+	t.Helper()
+	if !strings.HasPrefix(path, "/") {
+		t.Fatalf("Canonicalize(%q) = %q, not absolute", input, path)
+	}
+	for _, seq := range []string{"//", "/./", "/../", "\\"} {
+		if strings.Contains(path, seq) {
+			t.Fatalf("Canonicalize(%q) = %q, contains %q", input, path, seq)
+		}
+	}
+	for _, suffix := range []string{"/.", "/.."} {
+		if strings.HasSuffix(path, suffix) {
+			t.Fatalf("Canonicalize(%q) = %q, ends with %q", input, path, suffix)
+		}
+	}
+}
+
+func FuzzCanonicalize(f *testing.F) {
+	for _, seed := range []string{
+		"/", "/api/v1/devices", "/api//v1/", "/api/./v1/../v2", "/../..", "/api./x..",
+		"/a\\b\\..\\c", "/api/%2e%2e/admin", "/api%2F..%2Fadmin", "/api%252F", "/a/%00",
+		"/a/.../b/", "//", "/./", "/%2E%2E%2F",
+	} {
+		f.Add(seed, false, false)
+		f.Add(seed, true, true)
+	}
+
+	f.Fuzz(func(t *testing.T, target string, reject, decodeSlashes bool) {
+		u, err := url.ParseRequestURI(target)
+		if err != nil {
+			return
+		}
+		opts := Options{DecodeEncodedSlashes: decodeSlashes}
+		if reject {
+			opts.Policy = Reject
+		}
+
+		got, err := Canonicalize(u, opts)
+		if err != nil {
+			if !errors.Is(err, ErrAmbiguousPath) {
+				t.Fatalf("Canonicalize(%q) returned an unexpected error: %v", target, err)
+			}
+			return
+		}
+		checkCanonical(t, target, got)
+
+		// The canonical form is a fixed point under either policy
+		for _, policy := range []Policy{Normalize, Reject} {
+			again, err := Canonicalize(&url.URL{Path: got}, Options{Policy: policy})
+			if err != nil {
+				t.Fatalf("Canonicalize(%q) = %q, which is rejected under %s: %v", target, got, policy, err)
+			}
+			if again != got {
+				t.Fatalf("Canonicalize(%q) = %q, but canonicalizing that again gives %q", target, got, again)
+			}
+		}
+
+		// The reject policy only accepts paths that are already canonical
+		if reject && !decodeSlashes && got != u.Path {
+			t.Fatalf("Canonicalize(%q) under reject changed the path %q to %q", target, u.Path, got)
+		}
+	})
+}
@@ -0,0 +1,276 @@
+// Package pocketbase provides a client for interacting with the PocketBase API
+// to manage users, roles, and permissions.
+package pocketbase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"api-gateway/internal/jwtutil"
+)
+
+// renewBeforeExpiry is how long before the superuser token's expiry it is renewed
+const renewBeforeExpiry = 60 * time.Second
+
+// authState holds the superuser token and what is needed to renew it
+type authState struct {
+	mutex     sync.RWMutex
+	token     string
+	expiresAt time.Time // Zero if the token carries no expiry
+	identity  string
+	password  string
+	static    bool // Token was supplied directly, without a password
+
+	// impersonation renews a static token; nil if it never expires
+	impersonation *impersonation
+
+	// renewMutex serializes renewals so concurrent callers trigger only one
+	renewMutex sync.Mutex
+}
+
+// impersonation identifies the superuser record a static token was issued
+// for, so that PocketBase's impersonate API can issue its successor
+type impersonation struct {
+	collectionID string
+	recordID     string
+	duration     time.Duration // Lifetime of the renewed tokens
+}
+
+// UseAPIToken authenticates with a pre-issued superuser token instead of a
+// password, for example one created through PocketBase's impersonate API.
+// A token that expires is renewed through the impersonate API while it is
+// still valid, for the same lifetime; once expired, a new one must be
+// configured. Expiring tokens that don't name their superuser record are
+// rejected, since they could not be renewed.
+func (c *Client) UseAPIToken(token string) error {
+	if token == "" {
+		return fmt.Errorf("empty API token")
+	}
+
+	var renewal *impersonation
+	if claims, err := jwtutil.ParseUnverified(token); err == nil && !claims.Expiry().IsZero() {
+		expiresAt := claims.Expiry()
+		if time.Until(expiresAt) <= 0 {
+			return fmt.Errorf("API token expired at %s", expiresAt.Format(time.RFC3339))
+		}
+		if claims.CollectionID == "" || claims.RecordID == "" {
+			return fmt.Errorf("API token expires at %s but names no superuser record to renew it for", expiresAt.Format(time.RFC3339))
+		}
+
+		// Renewed tokens live as long as the configured one was issued for
+		duration := time.Until(expiresAt)
+		if issuedAt := claims.IssuedAtTime(); !issuedAt.IsZero() && expiresAt.After(issuedAt) {
+			duration = expiresAt.Sub(issuedAt)
+		}
+		renewal = &impersonation{
+			collectionID: claims.CollectionID,
+			recordID:     claims.RecordID,
+			duration:     duration,
+		}
+	}
+
+	c.auth.mutex.Lock()
+	c.auth.static = true
+	c.auth.identity = ""
+	c.auth.password = ""
+	c.auth.impersonation = renewal
+	c.auth.mutex.Unlock()
+
+	c.setToken(token)
+
+	if renewal != nil {
+		c.logger.Info("Using PocketBase API token, renewed through impersonation",
+			zap.Time("expires_at", c.tokenExpiry()),
+			zap.Duration("lifetime", renewal.duration))
+	} else {
+		c.logger.Info("Using PocketBase API token")
+	}
+
+	return nil
+}
+
+// setToken stores a new superuser token along with its expiry
+func (c *Client) setToken(token string) {
+	var expiresAt time.Time
+	if claims, err := jwtutil.ParseUnverified(token); err == nil {
+		expiresAt = claims.Expiry()
+	}
+
+	c.auth.mutex.Lock()
+	defer c.auth.mutex.Unlock()
+
+	c.auth.token = token
+	c.auth.expiresAt = expiresAt
+}
+
+// currentToken returns the superuser token, or an empty string if not authenticated
+func (c *Client) currentToken() string {
+	c.auth.mutex.RLock()
+	defer c.auth.mutex.RUnlock()
+
+	return c.auth.token
+}
+
+// tokenExpiry returns the expiry of the superuser token, or the zero time if unknown
+func (c *Client) tokenExpiry() time.Time {
+	c.auth.mutex.RLock()
+	defer c.auth.mutex.RUnlock()
+
+	return c.auth.expiresAt
+}
+
+// validToken returns a superuser token, renewing it first if it is about to expire
+func (c *Client) validToken(ctx context.Context) (string, error) {
+	token := c.currentToken()
+	if token == "" {
+		return "", fmt.Errorf("not authenticated")
+	}
+
+	expiresAt := c.tokenExpiry()
+	if expiresAt.IsZero() || time.Until(expiresAt) > c.renewWindow() {
+		return token, nil
+	}
+
+	// Renew proactively; if that fails, the old token may still work for a moment
+	renewed, err := c.renewToken(ctx, token)
+	if err != nil {
+		c.logger.Warn("Failed to renew PocketBase token before expiry", zap.Error(err))
+		return token, nil
+	}
+	return renewed, nil
+}
+
+// renewWindow returns how long before expiry the superuser token is renewed.
+// Impersonated tokens are renewed halfway through their lifetime, so that a
+// failed or missed renewal can be retried before the token is lost for good.
+func (c *Client) renewWindow() time.Duration {
+	c.auth.mutex.RLock()
+	defer c.auth.mutex.RUnlock()
+
+	if renewal := c.auth.impersonation; renewal != nil && renewal.duration/2 > renewBeforeExpiry {
+		return renewal.duration / 2
+	}
+	return renewBeforeExpiry
+}
+
+// renewToken obtains a new superuser token unless another caller already
+// replaced the stale one. Renewals are serialized.
+func (c *Client) renewToken(ctx context.Context, stale string) (string, error) {
+	c.auth.renewMutex.Lock()
+	defer c.auth.renewMutex.Unlock()
+
+	// Someone else renewed while we were waiting
+	if current := c.currentToken(); current != stale {
+		return current, nil
+	}
+
+	c.auth.mutex.RLock()
+	identity, password, static, renewal := c.auth.identity, c.auth.password, c.auth.static, c.auth.impersonation
+	c.auth.mutex.RUnlock()
+
+	var (
+		token string
+		err   error
+	)
+	switch {
+	case static && renewal != nil:
+		token, err = c.impersonate(ctx, stale, renewal)
+	case static || identity == "":
+		return "", fmt.Errorf("superuser token cannot be renewed without credentials")
+	default:
+		token, err = c.authenticateWithPassword(ctx, identity, password)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to renew superuser token: %w", err)
+	}
+
+	c.setToken(token)
+	c.logger.Info("Renewed PocketBase superuser token", zap.Time("expires_at", c.tokenExpiry()))
+	return token, nil
+}
+
+// doAuthorized sends a request built by newRequest with the superuser token,
+// retrying transient failures. If PocketBase answers 401, the token is
+// renewed once and the request retried.
+func (c *Client) doAuthorized(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	token, err := c.validToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.do(ctx, func(ctx context.Context) (*http.Request, error) {
+			req, err := newRequest(ctx)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			return req, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+
+		// The token was rejected; renew it and try exactly once more
+		renewed, renewErr := c.renewToken(ctx, token)
+		if renewErr != nil {
+			c.logger.Warn("PocketBase rejected superuser token and renewal failed", zap.Error(renewErr))
+			return resp, nil
+		}
+		resp.Body.Close()
+		token = renewed
+	}
+}
+
+// impersonate obtains a successor of a still valid static token through
+// PocketBase's impersonate API, which only superusers may call
+func (c *Client) impersonate(ctx context.Context, token string, renewal *impersonation) (string, error) {
+	jsonData, err := json.Marshal(map[string]int64{
+		"duration": int64(renewal.duration / time.Second),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal impersonate request: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/api/collections/%s/impersonate/%s", c.baseURL, renewal.collectionID, renewal.recordID)
+	resp, err := c.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create impersonate request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to send impersonate request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("impersonation failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var authResp PocketBaseAuthResponse
+	if err := json.Unmarshal(body, &authResp); err != nil {
+		return "", fmt.Errorf("failed to decode impersonate response: %w", err)
+	}
+	if authResp.Token == "" {
+		return "", fmt.Errorf("impersonate response carries no token")
+	}
+
+	return authResp.Token, nil
+}
@@ -4,12 +4,15 @@ package pocketbase
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -47,19 +50,37 @@ func (pt *PBTime) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// MarshalJSON encodes the time in RFC 3339 format, or as an empty string if unset
+func (pt PBTime) MarshalJSON() ([]byte, error) {
+	t := time.Time(pt)
+	if t.IsZero() {
+		return []byte(`""`), nil
+	}
+	return json.Marshal(t.Format(time.RFC3339Nano))
+}
+
 // Time converts PBTime to standard time.Time
 func (pt PBTime) Time() time.Time {
 	return time.Time(pt)
 }
 
+// ErrInvalidToken is returned (wrapped) by GetUserByToken when PocketBase
+// definitively rejects a token, as opposed to a transient failure
+var ErrInvalidToken = errors.New("invalid token")
+
 // Client is a PocketBase API client
 type Client struct {
 	baseURL        string
 	httpClient     *http.Client
-	authToken      string
+	streamClient   *http.Client // No overall timeout, for long-lived realtime streams
 	logger         *zap.Logger
 	userCollection string
 	roleCollection string
+	userList       ListOptions
+	roleList       ListOptions
+	retry          RetryOptions
+	fields         FieldMapping
+	auth           authState
 }
 
 // User represents a user in PocketBase
@@ -68,18 +89,28 @@ type User struct {
 	Username       string    `json:"username"`
 	Email          string    `json:"email"`
 	RoleID         string    `json:"role_id"` // Changed to role_id to match PocketBase
+	RoleIDs        []string  `json:"role_ids,omitempty"` // Optional multi-relation for additional roles
 	Active         bool      `json:"active"`
 	CollectionID   string    `json:"collectionId,omitempty"`
 	CollectionName string    `json:"collectionName,omitempty"`
 	Verified       bool      `json:"verified,omitempty"`
 	Created        PBTime    `json:"created"` // Changed to PBTime
 	Updated        PBTime    `json:"updated"` // Changed to PBTime
+	
+	// Extra holds the configured extra fields, as strings
+	Extra map[string]string `json:"extra,omitempty"`
+	// ExpandedRoles holds the roles returned through expand, if requested
+	ExpandedRoles []Role `json:"-"`
+	// TokenExpiresAt is when the token the user was resolved from expires,
+	// for tokens whose expiry isn't in their claims; zero if unknown
+	TokenExpiresAt time.Time `json:"-"`
 }
 
 // Role represents a role in PocketBase with permissions
 type Role struct {
 	ID                   string          `json:"id"`
 	Name                 string          `json:"name"`
+	ParentID             string          `json:"parent,omitempty"` // Optional relation to a role to inherit from
 	PublishPermissions   json.RawMessage `json:"publish_permissions"`
 	SubscribePermissions json.RawMessage `json:"subscribe_permissions"`
 	Created              PBTime          `json:"created"` // Changed to PBTime
@@ -103,52 +134,131 @@ type PocketBaseAuthResponse struct {
 
 // JWTResponse represents a response from the PocketBase auth-refresh endpoint
 type JWTResponse struct {
-	Token  string `json:"token"`
-	Record User   `json:"record"`
+	Token  string          `json:"token"`
+	Record json.RawMessage `json:"record"` // Decoded with the field mapping
+}
+
+// DefaultPerPage is the page size used when listing records if none is configured
+const DefaultPerPage = 200
+
+// MaxPerPage is the largest page size PocketBase accepts
+const MaxPerPage = 1000
+
+// listSort orders listed records by ID. Every collection has the field, and a
+// unique order means a record created during a listing can only push others
+// onto the next page, where they are read again, never past the page cursor.
+const listSort = "id"
+
+// ListOptions controls how a collection is listed
+type ListOptions struct {
+	// PerPage is the number of records requested per page
+	PerPage int
+	// Filter is a PocketBase filter expression, e.g. "active = true"
+	Filter string
+	// Fields limits the returned fields, e.g. "id,active,role_id"
+	Fields string
+	// SkipTotal skips the total count query; pages are read until a short page
+	SkipTotal bool
+}
+
+// Options contains optional client settings
+type Options struct {
+	// UserList controls how users are listed
+	UserList ListOptions
+	// RoleList controls how roles are listed
+	RoleList ListOptions
+	// Transport controls connection pooling and timeouts
+	Transport TransportOptions
+	// Retry controls retries of transient failures
+	Retry RetryOptions
+	// Fields maps user and role attributes to collection field names
+	Fields FieldMapping
 }
 
 // NewClient creates a new PocketBase client with optimized connection pooling
-func NewClient(baseURL, userCollection, roleCollection string, logger *zap.Logger) *Client {
+func NewClient(baseURL, userCollection, roleCollection string, opts Options, logger *zap.Logger) *Client {
+	transportOpts := opts.Transport.withDefaults()
+	
 	// Configure an optimized transport for connection pooling
 	transport := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
-			Timeout:   5 * time.Second,
-			KeepAlive: 30 * time.Second,
+			Timeout:   transportOpts.DialTimeout,
+			KeepAlive: transportOpts.KeepAlive,
 		}).DialContext,
 		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          100,
-		MaxIdleConnsPerHost:   10,
-		MaxConnsPerHost:       20,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   5 * time.Second,
+		MaxIdleConns:          transportOpts.MaxIdleConns,
+		MaxIdleConnsPerHost:   transportOpts.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       transportOpts.MaxConnsPerHost,
+		IdleConnTimeout:       transportOpts.IdleConnTimeout,
+		TLSHandshakeTimeout:   transportOpts.TLSHandshakeTimeout,
 		ExpectContinueTimeout: 1 * time.Second,
-		ResponseHeaderTimeout: 5 * time.Second,
+		ResponseHeaderTimeout: transportOpts.ResponseHeaderTimeout,
 	}
 
 	// Create client with the configured transport
 	httpClient := &http.Client{
 		Transport: transport,
-		Timeout:   10 * time.Second,
+		Timeout:   transportOpts.RequestTimeout,
 	}
 
 	logger.Debug("Created PocketBase client with optimized connection pooling",
-		zap.Int("maxIdleConns", 100),
-		zap.Int("maxIdleConnsPerHost", 10),
-		zap.Int("maxConnsPerHost", 20),
-		zap.Duration("idleConnTimeout", 90 * time.Second))
+		zap.Int("maxIdleConns", transportOpts.MaxIdleConns),
+		zap.Int("maxIdleConnsPerHost", transportOpts.MaxIdleConnsPerHost),
+		zap.Int("maxConnsPerHost", transportOpts.MaxConnsPerHost),
+		zap.Duration("idleConnTimeout", transportOpts.IdleConnTimeout),
+		zap.Duration("requestTimeout", transportOpts.RequestTimeout))
 
 	return &Client{
 		baseURL:        baseURL,
 		httpClient:     httpClient,
+		streamClient:   &http.Client{Transport: transport},
 		logger:         logger,
 		userCollection: userCollection,
 		roleCollection: roleCollection,
+		userList:       opts.UserList,
+		roleList:       opts.RoleList,
+		retry:          opts.Retry.withDefaults(),
+		fields:         opts.Fields.withDefaults(),
 	}
 }
 
-// Authenticate authenticates with PocketBase using admin credentials
+// UserCollection returns the name of the users collection
+func (c *Client) UserCollection() string {
+	return c.userCollection
+}
+
+// RoleCollection returns the name of the roles collection
+func (c *Client) RoleCollection() string {
+	return c.roleCollection
+}
+
+// Authenticate authenticates with PocketBase using superuser credentials.
+// The credentials are kept so that the token can be renewed transparently
+// before it expires or when PocketBase rejects it.
 func (c *Client) Authenticate(email, password string) error {
+	return c.AuthenticateContext(context.Background(), email, password)
+}
+
+// AuthenticateContext is like Authenticate but gives up when ctx is done
+func (c *Client) AuthenticateContext(ctx context.Context, email, password string) error {
+	c.auth.mutex.Lock()
+	c.auth.identity = email
+	c.auth.password = password
+	c.auth.mutex.Unlock()
+	
+	token, err := c.authenticateWithPassword(ctx, email, password)
+	if err != nil {
+		return err
+	}
+	
+	c.setToken(token)
+	c.logger.Info("Successfully authenticated with PocketBase")
+	return nil
+}
+
+// authenticateWithPassword obtains a new superuser token
+func (c *Client) authenticateWithPassword(ctx context.Context, email, password string) (string, error) {
 	data := map[string]string{
 		"identity": email,
 		"password": password,
@@ -156,155 +266,228 @@ func (c *Client) Authenticate(email, password string) error {
 
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		return fmt.Errorf("failed to marshal auth request: %w", err)
+		return "", fmt.Errorf("failed to marshal auth request: %w", err)
 	}
 
 	authEndpoint := fmt.Sprintf("%s/api/collections/_superusers/auth-with-password", c.baseURL)
 	c.logger.Debug("Authenticating with PocketBase", zap.String("endpoint", authEndpoint))
 
-	req, err := http.NewRequest("POST", authEndpoint, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create auth request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", authEndpoint, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create auth request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to send auth request: %w", err)
+		return "", fmt.Errorf("failed to send auth request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("authentication failed with status %d: %s", resp.StatusCode, string(body))
+		return "", fmt.Errorf("authentication failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
 	var authResp PocketBaseAuthResponse
 	if err := json.Unmarshal(body, &authResp); err != nil {
-		return fmt.Errorf("failed to decode auth response: %w", err)
+		return "", fmt.Errorf("failed to decode auth response: %w", err)
 	}
 
-	c.authToken = authResp.Token
-	c.logger.Info("Successfully authenticated with PocketBase")
-	return nil
+	return authResp.Token, nil
 }
 
-// GetAllUsers retrieves all users from PocketBase
+// GetAllUsers retrieves all active users from PocketBase, across all pages.
+// Prefer ForEachUser for large collections, as this holds every user in memory.
 func (c *Client) GetAllUsers() ([]User, error) {
-	if c.authToken == "" {
-		return nil, fmt.Errorf("not authenticated")
-	}
-
-	// Use the standard user collection API instead of trying to construct a custom endpoint
-	endpoint := fmt.Sprintf("%s/api/collections/%s/records", c.baseURL, c.userCollection)
-	c.logger.Debug("Fetching users from PocketBase", zap.String("endpoint", endpoint))
-
-	reqURL, err := url.Parse(endpoint)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse URL: %w", err)
-	}
-
-	query := reqURL.Query()
-	query.Set("perPage", "200") // Adjust based on expected user count
-	reqURL.RawQuery = query.Encode()
-
-	req, err := http.NewRequest("GET", reqURL.String(), nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create users request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+c.authToken)
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send users request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("users request failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	// Debug: Log raw response to see what we're getting
-	body, _ := io.ReadAll(resp.Body)
-	c.logger.Debug("Received users response", zap.String("body_preview", string(body[:min(len(body), 1000)])))
-
-	var usersResp PocketBaseListResponse[User]
-	if err := json.Unmarshal(body, &usersResp); err != nil {
-		return nil, fmt.Errorf("failed to decode users response: %w", err)
-	}
+	return c.GetAllUsersContext(context.Background())
+}
 
-	// Filter active users if needed
+// GetAllUsersContext is like GetAllUsers but gives up when ctx is done
+func (c *Client) GetAllUsersContext(ctx context.Context) ([]User, error) {
 	var activeUsers []User
-	for _, user := range usersResp.Items {
+	err := c.ForEachUserContext(ctx, func(user User) error {
 		if user.Active {
 			activeUsers = append(activeUsers, user)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	c.logger.Info("Retrieved users from PocketBase", 
-		zap.Int("total_count", len(usersResp.Items)), 
-		zap.Int("active_count", len(activeUsers)))
 	
 	return activeUsers, nil
 }
 
-// GetAllRoles retrieves all roles from PocketBase
-func (c *Client) GetAllRoles() ([]Role, error) {
-	if c.authToken == "" {
-		return nil, fmt.Errorf("not authenticated")
-	}
+// ForEachUser streams every user matching the configured filter to fn,
+// one page at a time, so memory stays bounded by the page size.
+// Inactive users are included; it is up to fn to check User.Active.
+func (c *Client) ForEachUser(fn func(User) error) error {
+	return c.ForEachUserContext(context.Background(), fn)
+}
 
-	endpoint := fmt.Sprintf("%s/api/collections/%s/records", c.baseURL, c.roleCollection)
-	
-	req, err := http.NewRequest("GET", endpoint, nil)
+// ForEachUserContext is like ForEachUser but gives up when ctx is done
+func (c *Client) ForEachUserContext(ctx context.Context, fn func(User) error) error {
+	total, active := 0, 0
+	err := listRecords(ctx, c, c.userCollection, c.userList, c.fields.DecodeUser, func(users []User) error {
+		for _, user := range users {
+			total++
+			if user.Active {
+				active++
+			}
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create roles request: %w", err)
+		return fmt.Errorf("failed to list users: %w", err)
 	}
+	
+	c.logger.Info("Retrieved users from PocketBase", 
+		zap.Int("total_count", total), 
+		zap.Int("active_count", active))
+	
+	return nil
+}
 
-	req.Header.Set("Authorization", "Bearer "+c.authToken)
+// GetAllRoles retrieves all roles from PocketBase, across all pages
+func (c *Client) GetAllRoles() ([]Role, error) {
+	return c.GetAllRolesContext(context.Background())
+}
 
-	resp, err := c.httpClient.Do(req)
+// GetAllRolesContext is like GetAllRoles but gives up when ctx is done
+func (c *Client) GetAllRolesContext(ctx context.Context) ([]Role, error) {
+	var roles []Role
+	err := listRecords(ctx, c, c.roleCollection, c.roleList, c.fields.DecodeRole, func(page []Role) error {
+		roles = append(roles, page...)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to send roles request: %w", err)
+		return nil, fmt.Errorf("failed to list roles: %w", err)
 	}
-	defer resp.Body.Close()
+	
+	c.logger.Info("Retrieved roles from PocketBase", zap.Int("count", len(roles)))
+	return roles, nil
+}
 
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("roles request failed with status %d: %s", resp.StatusCode, string(body))
+// listRecords pages through a collection and passes each page of records,
+// decoded with decode, to fn. Iteration stops at the first short page (or at
+// totalPages when totals are requested), or as soon as fn returns an error.
+func listRecords[T any](ctx context.Context, c *Client, collection string, opts ListOptions, decode func([]byte) (*T, error), fn func([]T) error) error {
+	endpoint := fmt.Sprintf("%s/api/collections/%s/records", c.baseURL, collection)
+	reqURL, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to parse URL: %w", err)
 	}
-
-	var rolesResp PocketBaseListResponse[Role]
-	if err := json.Unmarshal(body, &rolesResp); err != nil {
-		return nil, fmt.Errorf("failed to decode roles response: %w", err)
+	
+	perPage := opts.PerPage
+	if perPage <= 0 {
+		perPage = DefaultPerPage
+	}
+	
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("page", strconv.Itoa(page))
+		query.Set("perPage", strconv.Itoa(perPage))
+		// A stable order keeps records from shifting between pages when the
+		// collection changes during the listing
+		query.Set("sort", listSort)
+		if opts.Filter != "" {
+			query.Set("filter", opts.Filter)
+		}
+		if opts.Fields != "" {
+			query.Set("fields", opts.Fields)
+		}
+		if opts.SkipTotal {
+			query.Set("skipTotal", "1")
+		}
+		reqURL.RawQuery = query.Encode()
+		
+		c.logger.Debug("Fetching records from PocketBase", 
+			zap.String("collection", collection),
+			zap.Int("page", page))
+		
+		listURL := reqURL.String()
+		resp, err := c.doAuthorized(ctx, func(ctx context.Context) (*http.Request, error) {
+			req, err := http.NewRequestWithContext(ctx, "GET", listURL, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create list request: %w", err)
+			}
+			return req, nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to send list request: %w", err)
+		}
+		
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return fmt.Errorf("list request failed with status %d: %s", resp.StatusCode, string(body))
+		}
+		
+		var listResp PocketBaseListResponse[json.RawMessage]
+		err = json.NewDecoder(resp.Body).Decode(&listResp)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to decode list response: %w", err)
+		}
+		
+		items := make([]T, 0, len(listResp.Items))
+		for _, raw := range listResp.Items {
+			item, err := decode(raw)
+			if err != nil {
+				return err
+			}
+			items = append(items, *item)
+		}
+		
+		if err := fn(items); err != nil {
+			return err
+		}
+		
+		// Stop after the last page
+		if len(listResp.Items) < perPage {
+			return nil
+		}
+		if !opts.SkipTotal && page >= listResp.TotalPages {
+			return nil
+		}
 	}
-
-	c.logger.Info("Retrieved roles from PocketBase", zap.Int("count", len(rolesResp.Items)))
-	return rolesResp.Items, nil
 }
 
 // GetUserByToken validates a JWT token and retrieves the associated user
 // This uses PocketBase's auth-refresh endpoint to validate the token
 func (c *Client) GetUserByToken(token string) (*User, error) {
-	if c.authToken == "" {
+	return c.GetUserByTokenContext(context.Background(), token)
+}
+
+// GetUserByTokenContext is like GetUserByToken but gives up when ctx is done
+func (c *Client) GetUserByTokenContext(ctx context.Context, token string) (*User, error) {
+	if c.currentToken() == "" {
 		return nil, fmt.Errorf("client not authenticated")
 	}
 
 	// Use PocketBase's JWT verification via auth-refresh endpoint
 	endpoint := fmt.Sprintf("%s/api/collections/%s/auth-refresh", c.baseURL, c.userCollection)
 	
-	req, err := http.NewRequest("POST", endpoint, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create token validation request: %w", err)
+	// Fetch the user's roles in the same call if configured
+	if expand := c.fields.expand(); expand != "" {
+		endpoint += "?expand=" + url.QueryEscape(expand)
 	}
-
-	// Set the user's token in the Authorization header
-	req.Header.Set("Authorization", "Bearer "+token)
 	
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create token validation request: %w", err)
+		}
+		
+		// Set the user's token in the Authorization header
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req, nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to send token validation request: %w", err)
 	}
@@ -313,6 +496,12 @@ func (c *Client) GetUserByToken(token string) (*User, error) {
 	// If response is not 200 OK, the token is invalid
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
+		
+		// Client errors mean PocketBase rejected the token itself;
+		// anything else may be transient and says nothing about the token
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return nil, fmt.Errorf("%w: token validation failed with status %d: %s", ErrInvalidToken, resp.StatusCode, string(body))
+		}
 		return nil, fmt.Errorf("token validation failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
@@ -321,35 +510,41 @@ func (c *Client) GetUserByToken(token string) (*User, error) {
 	if err := json.NewDecoder(resp.Body).Decode(&jwtResp); err != nil {
 		return nil, fmt.Errorf("failed to decode token validation response: %w", err)
 	}
+	
+	user, err := c.fields.DecodeUser(jwtResp.Record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token validation response: %w", err)
+	}
 
 	// Check if the user is active
-	if !jwtResp.Record.Active {
-		return nil, fmt.Errorf("user account is inactive")
+	if !user.Active {
+		return nil, fmt.Errorf("%w: user account is inactive", ErrInvalidToken)
 	}
 
 	c.logger.Debug("Successfully validated user token", 
-		zap.String("user_id", jwtResp.Record.ID),
-		zap.String("username", jwtResp.Record.Username))
+		zap.String("user_id", user.ID),
+		zap.String("username", user.Username),
+		zap.Int("expanded_roles", len(user.ExpandedRoles)))
 	
-	return &jwtResp.Record, nil
+	return user, nil
 }
 
 // GetRoleByID retrieves a role by its ID
 func (c *Client) GetRoleByID(id string) (*Role, error) {
-	if c.authToken == "" {
-		return nil, fmt.Errorf("not authenticated")
-	}
+	return c.GetRoleByIDContext(context.Background(), id)
+}
 
-	endpoint := fmt.Sprintf("%s/api/collections/%s/records/%s", c.baseURL, c.roleCollection, id)
+// GetRoleByIDContext is like GetRoleByID but gives up when ctx is done
+func (c *Client) GetRoleByIDContext(ctx context.Context, id string) (*Role, error) {
+	endpoint := fmt.Sprintf("%s/api/collections/%s/records/%s", c.baseURL, c.roleCollection, url.PathEscape(id))
 	
-	req, err := http.NewRequest("GET", endpoint, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create role request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+c.authToken)
-
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.doAuthorized(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create role request: %w", err)
+		}
+		return req, nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to send role request: %w", err)
 	}
@@ -360,12 +555,34 @@ func (c *Client) GetRoleByID(id string) (*Role, error) {
 		return nil, fmt.Errorf("role request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	var role Role
-	if err := json.NewDecoder(resp.Body).Decode(&role); err != nil {
-		return nil, fmt.Errorf("failed to decode role response: %w", err)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read role response: %w", err)
 	}
+	
+	return c.fields.DecodeRole(body)
+}
 
-	return &role, nil
+// AllRoleIDs returns the IDs of every role assigned to the user.
+// The single role_id comes first, followed by any role_ids not already seen.
+func (u *User) AllRoleIDs() []string {
+	ids := make([]string, 0, len(u.RoleIDs)+1)
+	seen := make(map[string]bool, len(u.RoleIDs)+1)
+	
+	if u.RoleID != "" {
+		ids = append(ids, u.RoleID)
+		seen[u.RoleID] = true
+	}
+	
+	for _, id := range u.RoleIDs {
+		if id == "" || seen[id] {
+			continue
+		}
+		ids = append(ids, id)
+		seen[id] = true
+	}
+	
+	return ids
 }
 
 // GetPublishPermissions extracts the string array from JSON field
@@ -393,11 +610,3 @@ func (r *Role) GetSubscribePermissions() ([]string, error) {
 	}
 	return permissions, nil
 }
-
-// min returns the smaller of x or y
-func min(x, y int) int {
-    if x < y {
-        return x
-    }
-    return y
-}
@@ -0,0 +1,283 @@
+// Package pocketbase provides a client for interacting with the PocketBase API
+// to manage users, roles, and permissions.
+package pocketbase
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// UserFieldMapping names the user collection fields the gateway reads.
+// System fields (id, created, updated, ...) always use PocketBase's names.
+type UserFieldMapping struct {
+	Username string
+	Email    string
+	// RoleID is a single relation to the primary role
+	RoleID string
+	// RoleIDs is an optional multi relation to additional roles
+	RoleIDs string
+	Active  string
+	// Extra lists additional fields to keep on the user, e.g. "tenant"
+	Extra []string
+}
+
+// RoleFieldMapping names the role collection fields the gateway reads
+type RoleFieldMapping struct {
+	Name                 string
+	Parent               string
+	PublishPermissions   string
+	SubscribePermissions string
+}
+
+// FieldMapping maps the gateway's user and role attributes to collection fields.
+// Empty names fall back to the defaults in DefaultFieldMapping.
+type FieldMapping struct {
+	User UserFieldMapping
+	Role RoleFieldMapping
+	// ExpandRoles requests the user's role relations with expand,
+	// so validating a token also returns the user's roles
+	ExpandRoles bool
+}
+
+// DefaultFieldMapping returns the field names of the default schema
+func DefaultFieldMapping() FieldMapping {
+	return FieldMapping{
+		User: UserFieldMapping{
+			Username: "username",
+			Email:    "email",
+			RoleID:   "role_id",
+			RoleIDs:  "role_ids",
+			Active:   "active",
+		},
+		Role: RoleFieldMapping{
+			Name:                 "name",
+			Parent:               "parent",
+			PublishPermissions:   "publish_permissions",
+			SubscribePermissions: "subscribe_permissions",
+		},
+	}
+}
+
+// withDefaults fills empty field names from DefaultFieldMapping
+func (m FieldMapping) withDefaults() FieldMapping {
+	defaults := DefaultFieldMapping()
+	fill := func(name *string, fallback string) {
+		if *name == "" {
+			*name = fallback
+		}
+	}
+
+	fill(&m.User.Username, defaults.User.Username)
+	fill(&m.User.Email, defaults.User.Email)
+	fill(&m.User.RoleID, defaults.User.RoleID)
+	fill(&m.User.RoleIDs, defaults.User.RoleIDs)
+	fill(&m.User.Active, defaults.User.Active)
+	fill(&m.Role.Name, defaults.Role.Name)
+	fill(&m.Role.Parent, defaults.Role.Parent)
+	fill(&m.Role.PublishPermissions, defaults.Role.PublishPermissions)
+	fill(&m.Role.SubscribePermissions, defaults.Role.SubscribePermissions)
+	return m
+}
+
+// expand returns the value of the expand query parameter, or "" if roles are not expanded
+func (m *FieldMapping) expand() string {
+	if !m.ExpandRoles {
+		return ""
+	}
+	if m.User.RoleIDs == m.User.RoleID {
+		return m.User.RoleID
+	}
+	return m.User.RoleID + "," + m.User.RoleIDs
+}
+
+// pocketBaseRecord is a record decoded field by field
+type pocketBaseRecord map[string]json.RawMessage
+
+// recordField pairs a field name with the value it is decoded into
+type recordField struct {
+	name   string
+	target interface{}
+}
+
+// decode unmarshals a field into target; missing and null fields are left untouched
+func (r pocketBaseRecord) decode(field string, target interface{}) error {
+	raw, found := r[field]
+	if !found || string(raw) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(raw, target); err != nil {
+		return fmt.Errorf("field %q: %w", field, err)
+	}
+	return nil
+}
+
+// relation decodes a relation field, which holds a string for single
+// relations and an array of strings for multi relations
+func (r pocketBaseRecord) relation(field string) ([]string, error) {
+	raw, found := r[field]
+	if !found || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		if single == "" {
+			return nil, nil
+		}
+		return []string{single}, nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(raw, &multiple); err != nil {
+		return nil, fmt.Errorf("field %q: expected a relation: %w", field, err)
+	}
+	return multiple, nil
+}
+
+// DecodeUser decodes a user record using the field mapping
+func (m *FieldMapping) DecodeUser(data []byte) (*User, error) {
+	var record pocketBaseRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to decode user record: %w", err)
+	}
+
+	var user User
+	for _, f := range []recordField{
+		{"id", &user.ID},
+		{"collectionId", &user.CollectionID},
+		{"collectionName", &user.CollectionName},
+		{"verified", &user.Verified},
+		{"created", &user.Created},
+		{"updated", &user.Updated},
+		{m.User.Username, &user.Username},
+		{m.User.Email, &user.Email},
+		{m.User.Active, &user.Active},
+	} {
+		if err := record.decode(f.name, f.target); err != nil {
+			return nil, fmt.Errorf("failed to decode user record: %w", err)
+		}
+	}
+
+	// The primary role relation may be a multi relation in custom schemas;
+	// its first entry is the primary role and the rest are additional roles
+	roleIDs, err := record.relation(m.User.RoleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode user record: %w", err)
+	}
+	if len(roleIDs) > 0 {
+		user.RoleID = roleIDs[0]
+		user.RoleIDs = append(user.RoleIDs, roleIDs[1:]...)
+	}
+
+	if m.User.RoleIDs != m.User.RoleID {
+		additional, err := record.relation(m.User.RoleIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode user record: %w", err)
+		}
+		user.RoleIDs = append(user.RoleIDs, additional...)
+	}
+
+	// Keep the requested extra fields as strings
+	for _, field := range m.User.Extra {
+		raw, found := record[field]
+		if !found || string(raw) == "null" {
+			continue
+		}
+		if user.Extra == nil {
+			user.Extra = make(map[string]string, len(m.User.Extra))
+		}
+		user.Extra[field] = rawString(raw)
+	}
+
+	// Roles fetched through expand
+	if m.ExpandRoles {
+		roles, err := m.decodeExpandedRoles(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode user record: %w", err)
+		}
+		user.ExpandedRoles = roles
+	}
+
+	return &user, nil
+}
+
+// decodeExpandedRoles decodes the roles PocketBase returned in the record's expand object
+func (m *FieldMapping) decodeExpandedRoles(record pocketBaseRecord) ([]Role, error) {
+	var expand map[string]json.RawMessage
+	if err := record.decode("expand", &expand); err != nil {
+		return nil, err
+	}
+
+	var roles []Role
+	for _, field := range []string{m.User.RoleID, m.User.RoleIDs} {
+		raw, found := expand[field]
+		if !found {
+			continue
+		}
+
+		// Single relations expand to an object, multi relations to an array
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			items = []json.RawMessage{raw}
+		}
+
+		for _, item := range items {
+			role, err := m.DecodeRole(item)
+			if err != nil {
+				return nil, fmt.Errorf("expanded %q: %w", field, err)
+			}
+			roles = append(roles, *role)
+		}
+
+		// The same field can't be expanded twice
+		if m.User.RoleIDs == m.User.RoleID {
+			break
+		}
+	}
+
+	return roles, nil
+}
+
+// DecodeRole decodes a role record using the field mapping
+func (m *FieldMapping) DecodeRole(data []byte) (*Role, error) {
+	var record pocketBaseRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to decode role record: %w", err)
+	}
+
+	var role Role
+	for _, f := range []recordField{
+		{"id", &role.ID},
+		{"created", &role.Created},
+		{"updated", &role.Updated},
+		{m.Role.Name, &role.Name},
+	} {
+		if err := record.decode(f.name, f.target); err != nil {
+			return nil, fmt.Errorf("failed to decode role record: %w", err)
+		}
+	}
+
+	parents, err := record.relation(m.Role.Parent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode role record: %w", err)
+	}
+	if len(parents) > 0 {
+		role.ParentID = parents[0]
+	}
+
+	// Permissions stay raw and are parsed on use
+	role.PublishPermissions = record[m.Role.PublishPermissions]
+	role.SubscribePermissions = record[m.Role.SubscribePermissions]
+
+	return &role, nil
+}
+
+// rawString returns a JSON string's value, or the raw JSON for other values
+func rawString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return strings.TrimSpace(string(raw))
+}
@@ -0,0 +1,267 @@
+// Package pocketbase provides a client for interacting with the PocketBase API
+// to manage users, roles, and permissions.
+package pocketbase
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Realtime record actions sent by PocketBase
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// RealtimeEvent is a record change delivered through the PocketBase realtime API
+type RealtimeEvent struct {
+	// Collection is the name of the collection the record belongs to
+	Collection string
+	// Action is one of ActionCreate, ActionUpdate or ActionDelete
+	Action string
+	// Record is the raw record as sent by PocketBase
+	Record json.RawMessage
+	
+	// fields is the client's field mapping used to decode Record
+	fields *FieldMapping
+}
+
+// User decodes the event record as a user
+func (e *RealtimeEvent) User() (*User, error) {
+	return e.fields.DecodeUser(e.Record)
+}
+
+// Role decodes the event record as a role
+func (e *RealtimeEvent) Role() (*Role, error) {
+	return e.fields.DecodeRole(e.Record)
+}
+
+// RealtimeOptions configures the realtime subscription
+type RealtimeOptions struct {
+	// MinBackoff is the delay before the first reconnect attempt
+	MinBackoff time.Duration
+	// MaxBackoff caps the delay between reconnect attempts
+	MaxBackoff time.Duration
+	// OnConnect is called after every successful (re)subscription.
+	// The first connection reports reconnect=false.
+	OnConnect func(reconnect bool)
+	// OnDisconnect is called whenever an established subscription is lost
+	OnDisconnect func(err error)
+}
+
+// realtimeMessage is the payload of a record event
+type realtimeMessage struct {
+	Action string          `json:"action"`
+	Record json.RawMessage `json:"record"`
+}
+
+// sseEvent is a single server-sent event
+type sseEvent struct {
+	id   string
+	name string
+	data string
+}
+
+// SubscribeCollections subscribes to record changes in the user and role
+// collections and calls handler for every event until ctx is cancelled.
+// Lost connections are re-established with exponential backoff.
+func (c *Client) SubscribeCollections(ctx context.Context, opts RealtimeOptions, handler func(RealtimeEvent)) {
+	backoff := opts.MinBackoff
+	connected := false
+
+	for {
+		err := c.subscribeOnce(ctx, handler, func() {
+			if opts.OnConnect != nil {
+				opts.OnConnect(connected)
+			}
+			connected = true
+			backoff = opts.MinBackoff
+		})
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		c.logger.Warn("Realtime subscription lost, reconnecting",
+			zap.Error(err),
+			zap.Duration("backoff", backoff))
+		if opts.OnDisconnect != nil {
+			opts.OnDisconnect(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		// Exponential backoff up to the configured maximum
+		backoff *= 2
+		if backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+	}
+}
+
+// subscribeOnce opens one realtime connection, registers the subscriptions
+// and dispatches events until the stream ends or ctx is cancelled
+func (c *Client) subscribeOnce(ctx context.Context, handler func(RealtimeEvent), onSubscribed func()) error {
+	endpoint := fmt.Sprintf("%s/api/realtime", c.baseURL)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create realtime request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.streamClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to realtime API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("realtime connection failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	subscriptions := map[string]string{
+		c.userCollection + "/*": c.userCollection,
+		c.roleCollection + "/*": c.roleCollection,
+	}
+
+	return readSSE(resp.Body, func(event sseEvent) error {
+		// The first event carries the client ID needed to register subscriptions
+		if event.name == "PB_CONNECT" {
+			var connect struct {
+				ClientID string `json:"clientId"`
+			}
+			if err := json.Unmarshal([]byte(event.data), &connect); err != nil || connect.ClientID == "" {
+				connect.ClientID = event.id
+			}
+
+			topics := make([]string, 0, len(subscriptions))
+			for topic := range subscriptions {
+				topics = append(topics, topic)
+			}
+			if err := c.setSubscriptions(ctx, connect.ClientID, topics); err != nil {
+				return err
+			}
+
+			c.logger.Info("Subscribed to PocketBase realtime events", zap.Strings("topics", topics))
+			onSubscribed()
+			return nil
+		}
+
+		collection, found := subscriptions[event.name]
+		if !found {
+			return nil
+		}
+
+		var message realtimeMessage
+		if err := json.Unmarshal([]byte(event.data), &message); err != nil {
+			c.logger.Warn("Ignoring malformed realtime event",
+				zap.String("event", event.name),
+				zap.Error(err))
+			return nil
+		}
+
+		handler(RealtimeEvent{
+			Collection: collection,
+			Action:     message.Action,
+			Record:     message.Record,
+			fields:     &c.fields,
+		})
+		return nil
+	})
+}
+
+// setSubscriptions registers the topics for a realtime client
+func (c *Client) setSubscriptions(ctx context.Context, clientID string, topics []string) error {
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"clientId":      clientID,
+		"subscriptions": topics,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal subscription request: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/api/realtime", c.baseURL)
+	resp, err := c.doAuthorized(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create subscription request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send subscription request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("subscription request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// readSSE parses a server-sent event stream and calls fn for each event.
+// It returns when the stream ends or fn returns an error.
+func readSSE(r io.Reader, fn func(sseEvent) error) error {
+	scanner := bufio.NewScanner(r)
+	// Records can be large; allow lines up to 1 MiB
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var event sseEvent
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// A blank line dispatches the accumulated event
+		if line == "" {
+			if event.name != "" || len(data) > 0 {
+				event.data = strings.Join(data, "\n")
+				if err := fn(event); err != nil {
+					return err
+				}
+			}
+			event = sseEvent{}
+			data = data[:0]
+			continue
+		}
+
+		// Lines starting with a colon are comments
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			event.id = value
+		case "event":
+			event.name = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("realtime stream error: %w", err)
+	}
+	return io.EOF
+}
@@ -0,0 +1,328 @@
+package pocketbase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// subscription is a subscription request received by the fake server
+type subscription struct {
+	clientID      string
+	topics        []string
+	authorization string
+}
+
+// fakeRealtime is a PocketBase realtime API serving server-sent events
+type fakeRealtime struct {
+	t *testing.T
+
+	mutex    sync.Mutex
+	attempts []time.Time         // Times of all stream requests
+	streams  []chan string       // Per connection, events to send; closing drops the stream
+	failNext int                 // Number of stream requests to refuse
+	records  map[string][]string // Records listed per collection
+
+	connected     chan int // Connection number, once PB_CONNECT is sent
+	subscriptions chan subscription
+}
+
+func newFakeRealtime(t *testing.T) (*fakeRealtime, *httptest.Server) {
+	f := &fakeRealtime{
+		t:             t,
+		records:       make(map[string][]string),
+		connected:     make(chan int, 10),
+		subscriptions: make(chan subscription, 10),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/realtime", f.stream)
+	mux.HandleFunc("POST /api/realtime", f.subscribe)
+	mux.HandleFunc("GET /api/collections/{collection}/records", f.list)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.CloseClientConnections()
+		server.Close()
+	})
+	return f, server
+}
+
+// stream serves one realtime connection: PB_CONNECT, then queued events
+func (f *fakeRealtime) stream(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	f.attempts = append(f.attempts, time.Now())
+	if f.failNext > 0 {
+		f.failNext--
+		f.mutex.Unlock()
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	events := make(chan string, 10)
+	f.streams = append(f.streams, events)
+	n := len(f.streams)
+	f.mutex.Unlock()
+
+	if r.Header.Get("Accept") != "text/event-stream" {
+		f.t.Errorf("realtime request has Accept %q", r.Header.Get("Accept"))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher := w.(http.Flusher)
+	fmt.Fprintf(w, "id:client-%d\nevent:PB_CONNECT\ndata:{\"clientId\":\"client-%d\"}\n\n", n, n)
+	flusher.Flush()
+	f.connected <- n
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			io.WriteString(w, event)
+			flusher.Flush()
+		}
+	}
+}
+
+// subscribe records a subscription request
+func (f *fakeRealtime) subscribe(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ClientID      string   `json:"clientId"`
+		Subscriptions []string `json:"subscriptions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sort.Strings(body.Subscriptions)
+	f.subscriptions <- subscription{
+		clientID:      body.ClientID,
+		topics:        body.Subscriptions,
+		authorization: r.Header.Get("Authorization"),
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// list serves a single page of records
+func (f *fakeRealtime) list(w http.ResponseWriter, r *http.Request) {
+	collection := r.PathValue("collection")
+
+	f.mutex.Lock()
+	items := "[" + strings.Join(f.records[collection], ",") + "]"
+	f.mutex.Unlock()
+
+	fmt.Fprintf(w, `{"page":1,"perPage":200,"totalItems":1,"totalPages":1,"items":%s}`, items)
+}
+
+// send queues an event on connection n
+func (f *fakeRealtime) send(n int, name, data string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.streams[n-1] <- fmt.Sprintf("event: %s\ndata: %s\n\n", name, data)
+}
+
+// drop ends connection n, as a restarting server would
+func (f *fakeRealtime) drop(n int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	close(f.streams[n-1])
+}
+
+// receive waits for a value from ch
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		var zero T
+		return zero
+	}
+}
+
+func TestSubscribeCollections(t *testing.T) {
+	fake, server := newFakeRealtime(t)
+	fake.records["roles"] = []string{`{"id":"r1","name":"resynced","publish_permissions":[],"subscribe_permissions":[]}`}
+
+	client := NewClient(server.URL, "users", "roles", Options{}, zap.NewNop())
+	if err := client.UseAPIToken("superuser-token"); err != nil {
+		t.Fatal(err)
+	}
+
+	const minBackoff, maxBackoff = 50 * time.Millisecond, 200 * time.Millisecond
+	connects := make(chan bool, 10)
+	disconnects := make(chan error, 10)
+	resyncs := make(chan []Role, 10)
+	events := make(chan RealtimeEvent, 10)
+
+	done := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		defer close(done)
+		client.SubscribeCollections(ctx, RealtimeOptions{
+			MinBackoff: minBackoff,
+			MaxBackoff: maxBackoff,
+			OnConnect: func(reconnect bool) {
+				// Resync as the gateway does, since events may have been missed
+				if reconnect {
+					roles, err := client.GetAllRolesContext(ctx)
+					if err != nil {
+						t.Errorf("resync failed: %v", err)
+					}
+					resyncs <- roles
+				}
+				connects <- reconnect
+			},
+			OnDisconnect: func(err error) {
+				disconnects <- err
+			},
+		}, func(event RealtimeEvent) {
+			events <- event
+		})
+	}()
+
+	// Handshake: PB_CONNECT, then the subscription with the client ID
+	checkSubscription := func(n int) {
+		t.Helper()
+		if got := receive(t, fake.connected, "realtime connection"); got != n {
+			t.Fatalf("connection %d, want %d", got, n)
+		}
+		sub := receive(t, fake.subscriptions, "subscription request")
+		if want := fmt.Sprintf("client-%d", n); sub.clientID != want {
+			t.Errorf("subscribed client %q, want %q", sub.clientID, want)
+		}
+		if want := []string{"roles/*", "users/*"}; strings.Join(sub.topics, ",") != strings.Join(want, ",") {
+			t.Errorf("subscribed to %v, want %v", sub.topics, want)
+		}
+		if sub.authorization != "Bearer superuser-token" {
+			t.Errorf("subscription sent with Authorization %q", sub.authorization)
+		}
+	}
+	checkSubscription(1)
+	if reconnect := receive(t, connects, "OnConnect"); reconnect {
+		t.Error("first connection reported as a reconnect")
+	}
+
+	// Events are dispatched in order; unknown and malformed events are skipped
+	fake.send(1, "roles/*", `{"action":"update","record":{"id":"r1","name":"operators","publish_permissions":["a/#"],"subscribe_permissions":[]}}`)
+	fake.send(1, "posts/*", `{"action":"create","record":{"id":"p1"}}`)
+	fake.send(1, "users/*", `not json`)
+	fake.send(1, "users/*", `{"action":"delete","record":{"id":"u1","username":"alice","role_id":"r1","active":true}}`)
+
+	event := receive(t, events, "role event")
+	if event.Collection != "roles" || event.Action != ActionUpdate {
+		t.Fatalf("got %s event on %q, want update on roles", event.Action, event.Collection)
+	}
+	role, err := event.Role()
+	if err != nil || role.Name != "operators" {
+		t.Fatalf("decoded role %+v, %v", role, err)
+	}
+
+	event = receive(t, events, "user event")
+	if event.Collection != "users" || event.Action != ActionDelete {
+		t.Fatalf("got %s event on %q, want delete on users", event.Action, event.Collection)
+	}
+	user, err := event.User()
+	if err != nil || user.ID != "u1" || user.Username != "alice" {
+		t.Fatalf("decoded user %+v, %v", user, err)
+	}
+
+	// Dropping the stream reconnects after the minimum backoff and resyncs;
+	// the next failures back off exponentially up to the maximum
+	fake.mutex.Lock()
+	fake.failNext = 3
+	fake.mutex.Unlock()
+	fake.drop(1)
+
+	if err := receive(t, disconnects, "OnDisconnect"); err == nil {
+		t.Error("OnDisconnect called without an error")
+	}
+	checkSubscription(2)
+	if reconnect := receive(t, connects, "OnConnect after reconnect"); !reconnect {
+		t.Error("reconnection not reported as a reconnect")
+	}
+	roles := receive(t, resyncs, "resync")
+	if len(roles) != 1 || roles[0].Name != "resynced" {
+		t.Errorf("resync listed %+v", roles)
+	}
+
+	fake.mutex.Lock()
+	attempts := append([]time.Time(nil), fake.attempts...)
+	fake.mutex.Unlock()
+	if len(attempts) != 5 {
+		t.Fatalf("got %d connection attempts, want 5", len(attempts))
+	}
+	wantGaps := []time.Duration{minBackoff, 2 * minBackoff, maxBackoff, maxBackoff}
+	for i, want := range wantGaps {
+		if gap := attempts[i+1].Sub(attempts[i]); gap < want {
+			t.Errorf("attempt %d came %v after the previous one, want at least %v", i+2, gap, want)
+		}
+	}
+	// One disconnect for the dropped stream and one per refused attempt
+	for i := 0; i < 3; i++ {
+		receive(t, disconnects, "OnDisconnect for a refused attempt")
+	}
+
+	// Events flow on the new connection
+	fake.send(2, "roles/*", `{"action":"create","record":{"id":"r2","name":"viewers"}}`)
+	event = receive(t, events, "event after reconnect")
+	if event.Collection != "roles" || event.Action != ActionCreate {
+		t.Fatalf("got %s event on %q after reconnect", event.Action, event.Collection)
+	}
+
+	// Cancelling stops the subscription without reconnecting
+	cancel()
+	receive(t, done, "SubscribeCollections to return")
+}
+
+func TestReadSSE(t *testing.T) {
+	stream := strings.Join([]string{
+		": comment",
+		"id: 1",
+		"event: first",
+		"data: line one",
+		"data: line two",
+		"",
+		"",
+		"data:no space",
+		"",
+		"event: unterminated",
+	}, "\n")
+
+	var got []sseEvent
+	err := readSSE(strings.NewReader(stream), func(event sseEvent) error {
+		got = append(got, event)
+		return nil
+	})
+	if err != io.EOF {
+		t.Errorf("readSSE returned %v at the end of the stream, want io.EOF", err)
+	}
+
+	want := []sseEvent{
+		{id: "1", name: "first", data: "line one\nline two"},
+		{data: "no space"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got events %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Package pocketbase provides a client for interacting with the PocketBase API
+// to manage users, roles, and permissions.
+package pocketbase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TransportOptions contains the connection pool and timeout settings of the client.
+// Zero values fall back to the defaults in DefaultTransportOptions.
+type TransportOptions struct {
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	IdleConnTimeout       time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	// RequestTimeout bounds a single HTTP attempt, including reading the body
+	RequestTimeout time.Duration
+}
+
+// DefaultTransportOptions returns the transport settings used when none are configured
+func DefaultTransportOptions() TransportOptions {
+	return TransportOptions{
+		DialTimeout:           5 * time.Second,
+		KeepAlive:             30 * time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		MaxConnsPerHost:       20,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		RequestTimeout:        10 * time.Second,
+	}
+}
+
+// withDefaults fills zero fields from DefaultTransportOptions
+func (o TransportOptions) withDefaults() TransportOptions {
+	defaults := DefaultTransportOptions()
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = defaults.DialTimeout
+	}
+	if o.KeepAlive <= 0 {
+		o.KeepAlive = defaults.KeepAlive
+	}
+	if o.MaxIdleConns <= 0 {
+		o.MaxIdleConns = defaults.MaxIdleConns
+	}
+	if o.MaxIdleConnsPerHost <= 0 {
+		o.MaxIdleConnsPerHost = defaults.MaxIdleConnsPerHost
+	}
+	if o.MaxConnsPerHost <= 0 {
+		o.MaxConnsPerHost = defaults.MaxConnsPerHost
+	}
+	if o.IdleConnTimeout <= 0 {
+		o.IdleConnTimeout = defaults.IdleConnTimeout
+	}
+	if o.TLSHandshakeTimeout <= 0 {
+		o.TLSHandshakeTimeout = defaults.TLSHandshakeTimeout
+	}
+	if o.ResponseHeaderTimeout <= 0 {
+		o.ResponseHeaderTimeout = defaults.ResponseHeaderTimeout
+	}
+	if o.RequestTimeout <= 0 {
+		o.RequestTimeout = defaults.RequestTimeout
+	}
+	return o
+}
+
+// RetryOptions controls retries of transient failures.
+// Zero values fall back to the defaults in DefaultRetryOptions.
+type RetryOptions struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry; it doubles per retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryOptions returns the retry settings used when none are configured
+func DefaultRetryOptions() RetryOptions {
+	return RetryOptions{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+	}
+}
+
+// withDefaults fills zero fields from DefaultRetryOptions
+func (o RetryOptions) withDefaults() RetryOptions {
+	defaults := DefaultRetryOptions()
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = defaults.MaxAttempts
+	}
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = defaults.InitialBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = defaults.MaxBackoff
+	}
+	return o
+}
+
+// isTransientStatus reports whether a status code is worth retrying
+func isTransientStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// do sends a request built by newRequest, retrying network errors and
+// transient status codes with exponential backoff and jitter. The request
+// is rebuilt for every attempt so that bodies can be resent.
+// It gives up as soon as ctx is done.
+func (c *Client) do(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	backoff := c.retry.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.httpClient.Do(req)
+
+		// Decide whether this attempt is worth repeating
+		retryable := false
+		if err != nil {
+			retryable = ctx.Err() == nil && !errors.Is(err, context.Canceled)
+		} else if isTransientStatus(resp.StatusCode) {
+			retryable = true
+		}
+
+		if !retryable || attempt >= c.retry.MaxAttempts {
+			return resp, err
+		}
+
+		// Discard the failed response before retrying
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		// Full jitter keeps concurrent callers from retrying in lockstep
+		delay := time.Duration(rand.Int63n(int64(backoff) + 1))
+		c.logger.Debug("Retrying PocketBase request",
+			zap.String("method", req.Method),
+			zap.String("path", req.URL.Path),
+			zap.Int("attempt", attempt),
+			zap.Duration("delay", delay),
+			zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, ctx.Err())
+		case <-time.After(delay):
+		}
+
+		backoff *= 2
+		if backoff > c.retry.MaxBackoff {
+			backoff = c.retry.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Package responsecache keeps upstream responses in memory, bounded by their
+// total size, with least recently used responses evicted first.
+package responsecache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Directives are parsed Cache-Control directives, names in lower case
+type Directives map[string]string
+
+// ParseCacheControl parses the values of Cache-Control headers
+func ParseCacheControl(values []string) Directives {
+	directives := make(Directives)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			name, argument, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(argument, `"`)
+		}
+	}
+	return directives
+}
+
+// Has reports whether a directive is present
+func (d Directives) Has(name string) bool {
+	_, found := d[name]
+	return found
+}
+
+// Seconds returns the argument of a delta-seconds directive such as max-age
+func (d Directives) Seconds(name string) (int, bool) {
+	argument, found := d[name]
+	if !found {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(argument)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return seconds, true
+}
+
+// Lifetime returns how long a response stays fresh as set by its headers:
+// s-maxage, then max-age, then Expires, less the Age the response already
+// has. Returns false if the headers set no lifetime.
+func Lifetime(header http.Header, directives Directives) (time.Duration, bool) {
+	var lifetime time.Duration
+	if seconds, found := directives.Seconds("s-maxage"); found {
+		lifetime = time.Duration(seconds) * time.Second
+	} else if seconds, found := directives.Seconds("max-age"); found {
+		lifetime = time.Duration(seconds) * time.Second
+	} else if expires := header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			// Invalid dates such as "0" mean already expired
+			return 0, true
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		lifetime = expiresAt.Sub(date)
+	} else {
+		return 0, false
+	}
+
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil && age > 0 {
+		lifetime -= time.Duration(age) * time.Second
+	}
+	if lifetime < 0 {
+		lifetime = 0
+	}
+	return lifetime, true
+}
+
+// VaryHeaders returns the canonical names of the request headers a response
+// varies by. Returns false for "Vary: *", which can't be cached.
+func VaryHeaders(header http.Header) ([]string, bool) {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil, false
+			}
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names, true
+}
+
+// VaryValues records the request's values of the named headers
+func VaryValues(names []string, header http.Header) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		values[name] = joinValues(header.Values(name))
+	}
+	return values
+}
+
+// NotModified reports whether a conditional request is satisfied by a
+// response with the given validators. If-None-Match takes precedence over
+// If-Modified-Since.
+func NotModified(request http.Header, etag, lastModified string) bool {
+	if match := request.Get("If-None-Match"); match != "" {
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || weakETag(candidate) == weakETag(etag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := request.Get("If-Modified-Since"); since != "" && lastModified != "" {
+		sinceTime, err := http.ParseTime(since)
+		if err != nil {
+			return false
+		}
+		modified, err := http.ParseTime(lastModified)
+		return err == nil && !modified.After(sinceTime)
+	}
+
+	return false
+}
+
+// weakETag strips the weak prefix; If-None-Match uses weak comparison
+func weakETag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
+
+// joinValues joins multiple header values for comparison
+func joinValues(values []string) string {
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,186 @@
+// Package responsecache keeps upstream responses in memory, bounded by their
+// total size, with least recently used responses evicted first.
+package responsecache
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Entry is a cached response. Entries are not modified once stored; a
+// revalidated response is stored as a new entry.
+type Entry struct {
+	Status     int
+	Header     http.Header
+	Body       []byte
+	Vary       map[string]string // Request headers named by Vary -> their values when stored
+	StoredAt   time.Time
+	FreshUntil time.Time
+
+	// Where the response belongs, for purging
+	Route   string   // Path prefix of the route
+	Path    string   // Request path
+	UserID  string   // Set for responses cached per user
+	RoleIDs []string // Roles of the user who requested the response
+}
+
+// Fresh reports whether the entry can be served without asking the upstream
+func (e *Entry) Fresh(now time.Time) bool {
+	return now.Before(e.FreshUntil)
+}
+
+// Age is how long ago the entry was stored
+func (e *Entry) Age(now time.Time) time.Duration {
+	return now.Sub(e.StoredAt)
+}
+
+// MatchesVary reports whether the request has the header values the entry
+// was stored with
+func (e *Entry) MatchesVary(header http.Header) bool {
+	for name, value := range e.Vary {
+		if joinValues(header.Values(name)) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// size estimates the memory held by the entry
+func (e *Entry) size(key string) int64 {
+	size := int64(len(key) + len(e.Body) + len(e.Route) + len(e.Path) + len(e.UserID))
+	for name, values := range e.Header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	for name, value := range e.Vary {
+		size += int64(len(name) + len(value))
+	}
+	for _, roleID := range e.RoleIDs {
+		size += int64(len(roleID))
+	}
+	return size
+}
+
+// Stats describes the contents of the store
+type Stats struct {
+	Entries  int   `json:"entries"`
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+// storeItem is an entry in the LRU list
+type storeItem struct {
+	key         string
+	entry       *Entry
+	size        int64
+	retainUntil time.Time
+}
+
+// Store is a size-bounded LRU of responses, safe for concurrent use
+type Store struct {
+	mutex    sync.Mutex
+	maxBytes int64
+	size     int64
+	items    map[string]*list.Element
+	order    *list.List // Front is most recently used
+}
+
+// New creates a store holding at most maxBytes of responses
+func New(maxBytes int64) *Store {
+	return &Store{
+		maxBytes: maxBytes,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the entry for key, which may be stale. Entries past their
+// retention are removed on access.
+func (s *Store) Get(key string, now time.Time) (*Entry, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	element, found := s.items[key]
+	if !found {
+		return nil, false
+	}
+
+	item := element.Value.(*storeItem)
+	if !now.Before(item.retainUntil) {
+		s.removeElement(element)
+		return nil, false
+	}
+
+	s.order.MoveToFront(element)
+	return item.entry, true
+}
+
+// Set stores an entry until retainUntil, replacing any entry with the same
+// key, and returns the number of entries evicted to stay within the size
+// bound. Entries larger than the whole store are not stored.
+func (s *Store) Set(key string, entry *Entry, retainUntil time.Time) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if element, found := s.items[key]; found {
+		s.removeElement(element)
+	}
+
+	size := entry.size(key)
+	if size > s.maxBytes {
+		return 0
+	}
+
+	s.items[key] = s.order.PushFront(&storeItem{key: key, entry: entry, size: size, retainUntil: retainUntil})
+	s.size += size
+
+	evicted := 0
+	for s.size > s.maxBytes {
+		s.removeElement(s.order.Back())
+		evicted++
+	}
+	return evicted
+}
+
+// Purge removes the entries for which match returns true and returns how
+// many were removed
+func (s *Store) Purge(match func(entry *Entry) bool) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := 0
+	for element := s.order.Front(); element != nil; {
+		next := element.Next()
+		if match(element.Value.(*storeItem).entry) {
+			s.removeElement(element)
+			removed++
+		}
+		element = next
+	}
+	return removed
+}
+
+// Stats returns the number and total size of the stored entries
+func (s *Store) Stats() Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return Stats{
+		Entries:  s.order.Len(),
+		Bytes:    s.size,
+		MaxBytes: s.maxBytes,
+	}
+}
+
+// removeElement unlinks an element from both the list and the index.
+// Caller must hold the mutex.
+func (s *Store) removeElement(element *list.Element) {
+	item := element.Value.(*storeItem)
+	s.order.Remove(element)
+	delete(s.items, item.key)
+	s.size -= item.size
+}
@@ -0,0 +1,263 @@
+// Package revocation keeps a list of revoked tokens that are rejected even
+// though the identity store would still accept them.
+package revocation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"api-gateway/internal/jwtutil"
+)
+
+// Entry types
+const (
+	TypeToken = "token" // Value is the token hash from cache.TokenHasher
+	TypeJTI   = "jti"   // Value is the JWT ID
+	TypeUser  = "user"  // Value is the user ID; tokens issued before Before are revoked
+)
+
+// Entry is a single revocation
+type Entry struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	// Before is the cut-off for user entries
+	Before *time.Time `json:"before,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+	// ExpiresAt is when the entry can be forgotten, typically when the
+	// revoked token would have expired anyway. Nil keeps it forever.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// key identifies an entry; adding an entry with the same key replaces it
+func (e *Entry) key() string {
+	return e.Type + ":" + e.Value
+}
+
+// expired reports whether the entry can be forgotten
+func (e *Entry) expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
+}
+
+// Validate checks that the entry is well-formed
+func (e *Entry) Validate() error {
+	switch e.Type {
+	case TypeToken, TypeJTI:
+	case TypeUser:
+		if e.Before == nil || e.Before.IsZero() {
+			return fmt.Errorf("user revocations need a before time")
+		}
+	default:
+		return fmt.Errorf("unknown revocation type %q", e.Type)
+	}
+
+	if e.Value == "" {
+		return fmt.Errorf("revocation value is required")
+	}
+	return nil
+}
+
+// fileContents is the layout of the persisted list
+type fileContents struct {
+	Entries []Entry `json:"entries"`
+}
+
+// List is a revocation list, persisted to a JSON file if a path is set
+type List struct {
+	path    string
+	mutex   sync.RWMutex
+	entries map[string]Entry // Map type:value -> Entry
+}
+
+// New creates a revocation list, loading the entries persisted at path.
+// An empty path keeps the list in memory only.
+func New(path string) (*List, error) {
+	l := &List{
+		path:    path,
+		entries: make(map[string]Entry),
+	}
+
+	if path == "" {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revocation list: %w", err)
+	}
+
+	var contents fileContents
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return nil, fmt.Errorf("failed to decode revocation list: %w", err)
+	}
+
+	now := time.Now()
+	for i, entry := range contents.Entries {
+		if err := entry.Validate(); err != nil {
+			return nil, fmt.Errorf("revocation list entry %d: %w", i, err)
+		}
+		if !entry.expired(now) {
+			l.entries[entry.key()] = entry
+		}
+	}
+
+	return l, nil
+}
+
+// IsRevoked reports whether a token is revoked by its hash or JWT ID, and if
+// so the type of the matching entry. Claims may be nil for tokens that aren't
+// JWTs. User revocations are checked with IsUserRevoked once the token has
+// been resolved to a user.
+func (l *List) IsRevoked(tokenHash string, claims *jwtutil.Claims) (bool, string) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	if len(l.entries) == 0 {
+		return false, ""
+	}
+
+	now := time.Now()
+	check := func(typ, value string) (Entry, bool) {
+		entry, found := l.entries[typ+":"+value]
+		return entry, found && !entry.expired(now)
+	}
+
+	if entry, found := check(TypeToken, tokenHash); found {
+		return true, entry.Type
+	}
+	if claims == nil {
+		return false, ""
+	}
+
+	if claims.ID != "" {
+		if entry, found := check(TypeJTI, claims.ID); found {
+			return true, entry.Type
+		}
+	}
+
+	return false, ""
+}
+
+// IsUserRevoked reports whether a token of a user, issued at issuedAt, is
+// revoked. The user ID must be the one the identity store resolved the
+// token to, never one read from unverified claims. Tokens without an issue
+// time, such as API keys, count as issued before any user revocation.
+func (l *List) IsUserRevoked(userID string, issuedAt time.Time) bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	entry, found := l.entries[TypeUser+":"+userID]
+	if !found || entry.expired(time.Now()) {
+		return false
+	}
+	return issuedAt.IsZero() || issuedAt.Before(*entry.Before)
+}
+
+// Add revokes according to the entry, replacing an entry of the same type
+// and value. The entry takes effect even if persisting it fails.
+func (l *List) Add(entry Entry) error {
+	if err := entry.Validate(); err != nil {
+		return err
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.entries[entry.key()] = entry
+	return l.saveLocked()
+}
+
+// Remove lifts a revocation. Returns false if there was none.
+func (l *List) Remove(typ, value string) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	key := typ + ":" + value
+	if _, found := l.entries[key]; !found {
+		return false, nil
+	}
+	delete(l.entries, key)
+	return true, l.saveLocked()
+}
+
+// Entries returns the current entries, oldest first
+func (l *List) Entries() []Entry {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	now := time.Now()
+	entries := make([]Entry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		if !entry.expired(now) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries
+}
+
+// saveLocked drops expired entries and writes the list to disk atomically.
+// Caller must hold the write lock.
+func (l *List) saveLocked() error {
+	now := time.Now()
+	for key, entry := range l.entries {
+		if entry.expired(now) {
+			delete(l.entries, key)
+		}
+	}
+
+	if l.path == "" {
+		return nil
+	}
+
+	contents := fileContents{Entries: make([]Entry, 0, len(l.entries))}
+	for _, entry := range l.entries {
+		contents.Entries = append(contents.Entries, entry)
+	}
+	sort.Slice(contents.Entries, func(i, j int) bool {
+		return contents.Entries[i].CreatedAt.Before(contents.Entries[j].CreatedAt)
+	})
+
+	data, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode revocation list: %w", err)
+	}
+
+	// Write to a temporary file in the same directory, then rename over the old one
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create revocation list file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write revocation list: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write revocation list: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write revocation list: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return fmt.Errorf("failed to replace revocation list: %w", err)
+	}
+	return nil
+}
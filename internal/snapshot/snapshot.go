@@ -0,0 +1,149 @@
+// Package snapshot persists the last known good roles and cached tokens to
+// disk, so the gateway can start in degraded mode while its identity store
+// is unreachable.
+package snapshot
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"api-gateway/internal/cache"
+	"api-gateway/internal/pocketbase"
+)
+
+// version is the current snapshot format version
+const version = 1
+
+// encryptedMagic prefixes encrypted snapshot files
+var encryptedMagic = []byte("APIGW-SNAPSHOT-GCM1\n")
+
+// ErrNotFound is returned by Load when no snapshot has been written yet
+var ErrNotFound = errors.New("snapshot not found")
+
+// Snapshot is the persisted state
+type Snapshot struct {
+	Version   int                `json:"version"`
+	CreatedAt time.Time          `json:"createdAt"`
+	Roles     []pocketbase.Role  `json:"roles"`
+	Tokens    []cache.TokenEntry `json:"tokens"`
+}
+
+// Store reads and writes snapshots at a path, encrypting them with
+// AES-GCM when a key is set
+type Store struct {
+	path string
+	aead cipher.AEAD // Nil if snapshots are stored in plain text
+}
+
+// NewStore creates a snapshot store. The key must be empty (no encryption)
+// or 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256.
+func NewStore(path string, key []byte) (*Store, error) {
+	s := &Store{path: path}
+
+	if len(key) > 0 {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot encryption key: %w", err)
+		}
+		s.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up snapshot encryption: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Save writes a snapshot of the given roles and tokens. The file is replaced
+// atomically and is only readable by the owner.
+func (s *Store) Save(roles []pocketbase.Role, tokens []cache.TokenEntry) error {
+	data, err := json.Marshal(Snapshot{
+		Version:   version,
+		CreatedAt: time.Now().UTC(),
+		Roles:     roles,
+		Tokens:    tokens,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	if s.aead != nil {
+		nonce := make([]byte, s.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return fmt.Errorf("failed to generate nonce: %w", err)
+		}
+		sealed := append(append([]byte{}, encryptedMagic...), nonce...)
+		data = s.aead.Seal(sealed, nonce, data, encryptedMagic)
+	}
+
+	// Write to a temporary file in the same directory, then rename over the old one
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace snapshot: %w", err)
+	}
+	return nil
+}
+
+// Load reads the snapshot. Returns ErrNotFound if there is none.
+func (s *Store) Load() (*Snapshot, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	encrypted := bytes.HasPrefix(data, encryptedMagic)
+	switch {
+	case encrypted && s.aead == nil:
+		return nil, fmt.Errorf("snapshot is encrypted but no encryption key is configured")
+	case !encrypted && s.aead != nil:
+		return nil, fmt.Errorf("snapshot is not encrypted but an encryption key is configured")
+	case encrypted:
+		data = data[len(encryptedMagic):]
+		if len(data) < s.aead.NonceSize() {
+			return nil, fmt.Errorf("snapshot is truncated")
+		}
+		nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+		data, err = s.aead.Open(nil, nonce, ciphertext, encryptedMagic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt snapshot (wrong key or corrupted file): %w", err)
+		}
+	}
+
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	if snap.Version != version {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+
+	return &snap, nil
+}
@@ -0,0 +1,310 @@
+// Package store defines the identity store used by the gateway to resolve
+// tokens, users and roles, with implementations besides PocketBase.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"api-gateway/internal/pocketbase"
+)
+
+// fileRole is a role as written in the store file
+type fileRole struct {
+	ID                   string   `json:"id" yaml:"id"`
+	Name                 string   `json:"name" yaml:"name"`
+	Parent               string   `json:"parent" yaml:"parent"`
+	PublishPermissions   []string `json:"publish_permissions" yaml:"publish_permissions"`
+	SubscribePermissions []string `json:"subscribe_permissions" yaml:"subscribe_permissions"`
+}
+
+// fileUser is a user as written in the store file
+type fileUser struct {
+	ID       string            `json:"id" yaml:"id"`
+	Username string            `json:"username" yaml:"username"`
+	Email    string            `json:"email" yaml:"email"`
+	RoleID   string            `json:"role_id" yaml:"role_id"`
+	RoleIDs  []string          `json:"role_ids" yaml:"role_ids"`
+	Active   *bool             `json:"active" yaml:"active"` // Defaults to true
+	Extra    map[string]string `json:"extra" yaml:"extra"`
+	APIKeys  []string          `json:"api_keys" yaml:"api_keys"` // SHA-256 hashes, see HashAPIKey
+}
+
+// fileContents is the layout of the store file
+type fileContents struct {
+	Roles []fileRole `json:"roles" yaml:"roles"`
+	Users []fileUser `json:"users" yaml:"users"`
+}
+
+// fileSnapshot is the parsed and indexed content of the store file
+type fileSnapshot struct {
+	roles      []pocketbase.Role
+	rolesByID  map[string]*pocketbase.Role
+	users      []pocketbase.User
+	usersByKey map[string]*pocketbase.User // Map API key hash -> User
+	modTime    time.Time
+}
+
+// FileStore serves users, roles and hashed API keys from a static YAML or
+// JSON file. The file is re-read on refresh when its modification time changes.
+type FileStore struct {
+	path     string
+	logger   *zap.Logger
+	mutex    sync.RWMutex
+	snapshot *fileSnapshot
+}
+
+// NewFileStore loads the store file at path
+func NewFileStore(path string, logger *zap.Logger) (*FileStore, error) {
+	s := &FileStore{
+		path:   path,
+		logger: logger,
+	}
+
+	snapshot, err := loadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s.snapshot = snapshot
+
+	logger.Info("Loaded identity store file",
+		zap.String("path", path),
+		zap.Int("users", len(snapshot.users)),
+		zap.Int("roles", len(snapshot.roles)))
+
+	return s, nil
+}
+
+// GetUserByTokenContext resolves an API key to its user
+func (s *FileStore) GetUserByTokenContext(ctx context.Context, token string) (*pocketbase.User, error) {
+	s.mutex.RLock()
+	user, found := s.snapshot.usersByKey[HashAPIKey(token)]
+	s.mutex.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("%w: unknown API key", ErrInvalidToken)
+	}
+	if !user.Active {
+		return nil, fmt.Errorf("%w: user account is inactive", ErrInvalidToken)
+	}
+
+	// Hand out a copy so callers can't modify the snapshot
+	copied := *user
+	return &copied, nil
+}
+
+// GetRoleByIDContext returns a single role
+func (s *FileStore) GetRoleByIDContext(ctx context.Context, id string) (*pocketbase.Role, error) {
+	s.mutex.RLock()
+	role, found := s.snapshot.rolesByID[id]
+	s.mutex.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("role %s not found", id)
+	}
+
+	copied := *role
+	return &copied, nil
+}
+
+// GetAllRolesContext returns every role, re-reading the file if it changed
+func (s *FileStore) GetAllRolesContext(ctx context.Context) ([]pocketbase.Role, error) {
+	s.reloadIfChanged()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	roles := make([]pocketbase.Role, len(s.snapshot.roles))
+	copy(roles, s.snapshot.roles)
+	return roles, nil
+}
+
+// ForEachUserContext passes every user to fn, re-reading the file if it changed
+func (s *FileStore) ForEachUserContext(ctx context.Context, fn func(pocketbase.User) error) error {
+	s.reloadIfChanged()
+
+	s.mutex.RLock()
+	users := s.snapshot.users
+	s.mutex.RUnlock()
+
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reloadIfChanged re-reads the store file if its modification time changed.
+// A file that fails to load is logged and the previous content is kept.
+func (s *FileStore) reloadIfChanged() {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		s.logger.Error("Failed to stat identity store file", zap.Error(err))
+		return
+	}
+
+	s.mutex.RLock()
+	unchanged := info.ModTime().Equal(s.snapshot.modTime)
+	s.mutex.RUnlock()
+	if unchanged {
+		return
+	}
+
+	snapshot, err := loadFile(s.path)
+	if err != nil {
+		s.logger.Error("Failed to reload identity store file, keeping previous content", zap.Error(err))
+		return
+	}
+
+	s.mutex.Lock()
+	s.snapshot = snapshot
+	s.mutex.Unlock()
+
+	s.logger.Info("Reloaded identity store file",
+		zap.Int("users", len(snapshot.users)),
+		zap.Int("roles", len(snapshot.roles)))
+}
+
+// loadFile reads, validates and indexes the store file
+func loadFile(path string) (*fileSnapshot, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity store file: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity store file: %w", err)
+	}
+
+	// The extension decides the format
+	var contents fileContents
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &contents)
+	case ".json":
+		err = json.Unmarshal(data, &contents)
+	default:
+		return nil, fmt.Errorf("identity store file must have a .yaml, .yml or .json extension")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity store file: %w", err)
+	}
+
+	snapshot := &fileSnapshot{
+		rolesByID:  make(map[string]*pocketbase.Role, len(contents.Roles)),
+		usersByKey: make(map[string]*pocketbase.User),
+		modTime:    info.ModTime(),
+	}
+
+	// Convert and index roles
+	snapshot.roles = make([]pocketbase.Role, 0, len(contents.Roles))
+	for i, r := range contents.Roles {
+		if r.ID == "" {
+			return nil, fmt.Errorf("roles[%d].id is required", i)
+		}
+		if _, found := snapshot.rolesByID[r.ID]; found {
+			return nil, fmt.Errorf("roles[%d]: duplicate role id %s", i, r.ID)
+		}
+
+		role, err := newRole(r.ID, r.Name, r.Parent, r.PublishPermissions, r.SubscribePermissions)
+		if err != nil {
+			return nil, fmt.Errorf("roles[%d]: %w", i, err)
+		}
+		snapshot.roles = append(snapshot.roles, *role)
+		snapshot.rolesByID[r.ID] = &snapshot.roles[len(snapshot.roles)-1]
+	}
+
+	// Check parent references once all roles are known
+	for _, role := range snapshot.roles {
+		if role.ParentID != "" && snapshot.rolesByID[role.ParentID] == nil {
+			return nil, fmt.Errorf("role %s: unknown parent role %s", role.ID, role.ParentID)
+		}
+	}
+
+	// Convert users and index them by API key hash
+	snapshot.users = make([]pocketbase.User, 0, len(contents.Users))
+	seenUsers := make(map[string]bool, len(contents.Users))
+	for i, u := range contents.Users {
+		if u.ID == "" {
+			return nil, fmt.Errorf("users[%d].id is required", i)
+		}
+		if seenUsers[u.ID] {
+			return nil, fmt.Errorf("users[%d]: duplicate user id %s", i, u.ID)
+		}
+		seenUsers[u.ID] = true
+
+		user := pocketbase.User{
+			ID:       u.ID,
+			Username: u.Username,
+			Email:    u.Email,
+			RoleID:   u.RoleID,
+			RoleIDs:  u.RoleIDs,
+			Active:   u.Active == nil || *u.Active,
+			Extra:    u.Extra,
+		}
+
+		for _, roleID := range user.AllRoleIDs() {
+			if snapshot.rolesByID[roleID] == nil {
+				return nil, fmt.Errorf("user %s: unknown role %s", u.ID, roleID)
+			}
+		}
+
+		snapshot.users = append(snapshot.users, user)
+	}
+
+	for i, u := range contents.Users {
+		for _, key := range u.APIKeys {
+			hash := strings.ToLower(key)
+			if !isKeyHash(hash) {
+				return nil, fmt.Errorf("user %s: API keys must be hex-encoded SHA-256 hashes", u.ID)
+			}
+			if _, found := snapshot.usersByKey[hash]; found {
+				return nil, fmt.Errorf("user %s: API key hash is already assigned", u.ID)
+			}
+			snapshot.usersByKey[hash] = &snapshot.users[i]
+		}
+	}
+
+	return snapshot, nil
+}
+
+// newRole builds a role with its permission lists encoded the way PocketBase stores them
+func newRole(id, name, parent string, publish, subscribe []string) (*pocketbase.Role, error) {
+	if publish == nil {
+		publish = []string{}
+	}
+	if subscribe == nil {
+		subscribe = []string{}
+	}
+
+	publishJSON, err := json.Marshal(publish)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode publish permissions: %w", err)
+	}
+	subscribeJSON, err := json.Marshal(subscribe)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode subscribe permissions: %w", err)
+	}
+
+	return &pocketbase.Role{
+		ID:                   id,
+		Name:                 name,
+		ParentID:             parent,
+		PublishPermissions:   publishJSON,
+		SubscribePermissions: subscribeJSON,
+	}, nil
+}
@@ -0,0 +1,295 @@
+// Package store defines the identity store used by the gateway to resolve
+// tokens, users and roles, with implementations besides PocketBase.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite" // Registers the "sqlite" driver, in pure Go so builds need no cgo
+
+	"api-gateway/internal/pocketbase"
+)
+
+// sqliteSchema creates the tables used by SQLiteStore if they don't exist.
+// Permissions are JSON arrays of patterns; API keys are stored as HashAPIKey
+// hashes with an optional expiry in Unix seconds.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS roles (
+	id                    TEXT PRIMARY KEY,
+	name                  TEXT NOT NULL,
+	parent                TEXT REFERENCES roles(id),
+	publish_permissions   TEXT NOT NULL DEFAULT '[]',
+	subscribe_permissions TEXT NOT NULL DEFAULT '[]'
+);
+CREATE TABLE IF NOT EXISTS users (
+	id       TEXT PRIMARY KEY,
+	username TEXT NOT NULL DEFAULT '',
+	email    TEXT NOT NULL DEFAULT '',
+	role_id  TEXT REFERENCES roles(id),
+	active   INTEGER NOT NULL DEFAULT 1
+);
+CREATE TABLE IF NOT EXISTS user_roles (
+	user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	role_id TEXT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+	PRIMARY KEY (user_id, role_id)
+);
+CREATE TABLE IF NOT EXISTS user_fields (
+	user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name    TEXT NOT NULL,
+	value   TEXT NOT NULL,
+	PRIMARY KEY (user_id, name)
+);
+CREATE TABLE IF NOT EXISTS api_keys (
+	key_hash   TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	expires_at INTEGER
+);
+`
+
+// SQLiteStore serves users, roles and hashed API keys from a SQLite database
+type SQLiteStore struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+// NewSQLiteStore opens the database at path and creates the schema if needed
+func NewSQLiteStore(path string, logger *zap.Logger) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create SQLite schema: %w", err)
+	}
+
+	logger.Info("Opened identity store database", zap.String("path", path))
+
+	return &SQLiteStore{
+		db:     db,
+		logger: logger,
+	}, nil
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// GetUserByTokenContext resolves an API key to its user
+func (s *SQLiteStore) GetUserByTokenContext(ctx context.Context, token string) (*pocketbase.User, error) {
+	var (
+		user      pocketbase.User
+		roleID    sql.NullString
+		expiresAt sql.NullInt64
+	)
+	err := s.db.QueryRowContext(ctx, `
+		SELECT u.id, u.username, u.email, u.role_id, u.active, k.expires_at
+		FROM api_keys k JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = ?`, HashAPIKey(token)).
+		Scan(&user.ID, &user.Username, &user.Email, &roleID, &user.Active, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: unknown API key", ErrInvalidToken)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up API key: %w", err)
+	}
+	user.RoleID = roleID.String
+
+	if expiresAt.Valid {
+		if time.Now().Unix() >= expiresAt.Int64 {
+			return nil, fmt.Errorf("%w: API key expired", ErrInvalidToken)
+		}
+		// The key must drop out of the token cache when it expires
+		user.TokenExpiresAt = time.Unix(expiresAt.Int64, 0)
+	}
+	if !user.Active {
+		return nil, fmt.Errorf("%w: user account is inactive", ErrInvalidToken)
+	}
+
+	// Additional roles and extra fields
+	additionalRoles, err := s.userRoles(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	user.RoleIDs = additionalRoles[user.ID]
+
+	extra, err := s.userFields(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	user.Extra = extra[user.ID]
+
+	return &user, nil
+}
+
+// GetRoleByIDContext returns a single role
+func (s *SQLiteStore) GetRoleByIDContext(ctx context.Context, id string) (*pocketbase.Role, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT id, name, parent, publish_permissions, subscribe_permissions
+		FROM roles WHERE id = ?`, id)
+
+	role, err := scanRole(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("role %s not found", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+	return role, nil
+}
+
+// GetAllRolesContext returns every role
+func (s *SQLiteStore) GetAllRolesContext(ctx context.Context) ([]pocketbase.Role, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, parent, publish_permissions, subscribe_permissions
+		FROM roles ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []pocketbase.Role
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list roles: %w", err)
+		}
+		roles = append(roles, *role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+// ForEachUserContext passes every user to fn
+func (s *SQLiteStore) ForEachUserContext(ctx context.Context, fn func(pocketbase.User) error) error {
+	// Load the small side tables up front so users can be streamed
+	additionalRoles, err := s.userRoles(ctx, "")
+	if err != nil {
+		return err
+	}
+	extra, err := s.userFields(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, username, email, role_id, active FROM users ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			user   pocketbase.User
+			roleID sql.NullString
+		)
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &roleID, &user.Active); err != nil {
+			return fmt.Errorf("failed to list users: %w", err)
+		}
+		user.RoleID = roleID.String
+		user.RoleIDs = additionalRoles[user.ID]
+		user.Extra = extra[user.ID]
+
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+
+	return nil
+}
+
+// userRoles returns the additional role IDs per user, for one user or all if userID is empty
+func (s *SQLiteStore) userRoles(ctx context.Context, userID string) (map[string][]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id, role_id FROM user_roles
+		WHERE ? = '' OR user_id = ? ORDER BY user_id, role_id`, userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := make(map[string][]string)
+	for rows.Next() {
+		var user, role string
+		if err := rows.Scan(&user, &role); err != nil {
+			return nil, fmt.Errorf("failed to list user roles: %w", err)
+		}
+		roles[user] = append(roles[user], role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list user roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+// userFields returns the extra fields per user, for one user or all if userID is empty
+func (s *SQLiteStore) userFields(ctx context.Context, userID string) (map[string]map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id, name, value FROM user_fields
+		WHERE ? = '' OR user_id = ?`, userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user fields: %w", err)
+	}
+	defer rows.Close()
+
+	fields := make(map[string]map[string]string)
+	for rows.Next() {
+		var user, name, value string
+		if err := rows.Scan(&user, &name, &value); err != nil {
+			return nil, fmt.Errorf("failed to list user fields: %w", err)
+		}
+		if fields[user] == nil {
+			fields[user] = make(map[string]string)
+		}
+		fields[user][name] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list user fields: %w", err)
+	}
+
+	return fields, nil
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanRole reads a role row, checking that the permissions are valid JSON
+func scanRole(row rowScanner) (*pocketbase.Role, error) {
+	var (
+		role               pocketbase.Role
+		parent             sql.NullString
+		publish, subscribe string
+	)
+	if err := row.Scan(&role.ID, &role.Name, &parent, &publish, &subscribe); err != nil {
+		return nil, err
+	}
+	role.ParentID = parent.String
+
+	if !json.Valid([]byte(publish)) {
+		return nil, fmt.Errorf("role %s: publish_permissions is not valid JSON", role.ID)
+	}
+	if !json.Valid([]byte(subscribe)) {
+		return nil, fmt.Errorf("role %s: subscribe_permissions is not valid JSON", role.ID)
+	}
+	role.PublishPermissions = json.RawMessage(publish)
+	role.SubscribePermissions = json.RawMessage(subscribe)
+
+	return &role, nil
+}
@@ -0,0 +1,62 @@
+// Package store defines the identity store used by the gateway to resolve
+// tokens, users and roles, with implementations besides PocketBase.
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"api-gateway/internal/pocketbase"
+)
+
+// Store types selectable in the configuration
+const (
+	TypePocketBase = "pocketbase"
+	TypeFile       = "file"
+	TypeSQLite     = "sqlite"
+)
+
+// ErrInvalidToken is returned (wrapped) when a store definitively rejects a token.
+// It is the same error as pocketbase.ErrInvalidToken, so either can be checked.
+var ErrInvalidToken = pocketbase.ErrInvalidToken
+
+// UserRoleStore is the source of users and roles for the gateway.
+// *pocketbase.Client implements it.
+type UserRoleStore interface {
+	// GetUserByTokenContext resolves a bearer token to an active user.
+	// Unknown tokens and inactive users are reported with ErrInvalidToken.
+	GetUserByTokenContext(ctx context.Context, token string) (*pocketbase.User, error)
+
+	// GetRoleByIDContext returns a single role
+	GetRoleByIDContext(ctx context.Context, id string) (*pocketbase.Role, error)
+
+	// GetAllRolesContext returns every role
+	GetAllRolesContext(ctx context.Context) ([]pocketbase.Role, error)
+
+	// ForEachUserContext passes every user, including inactive ones, to fn
+	ForEachUserContext(ctx context.Context, fn func(pocketbase.User) error) error
+}
+
+// Every store implements UserRoleStore
+var (
+	_ UserRoleStore = (*pocketbase.Client)(nil)
+	_ UserRoleStore = (*FileStore)(nil)
+	_ UserRoleStore = (*SQLiteStore)(nil)
+)
+
+// HashAPIKey returns the hex-encoded SHA-256 hash of an API key, the form in
+// which the file and SQLite stores keep keys
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// isKeyHash reports whether s looks like a hash produced by HashAPIKey
+func isKeyHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,59 @@
+// Package permissions provides functionality for checking permission patterns
+// against HTTP paths using MQTT-style and NATS-style topic pattern matching.
+package permissions
+
+import (
+	"regexp"
+	"strings"
+)
+
+// templateVariable matches placeholders such as ${user.id} or ${user.tenant}
+var templateVariable = regexp.MustCompile(`\$\{([A-Za-z0-9_.]+)\}`)
+
+// ExpandTemplates substitutes ${name} placeholders in permission patterns with
+// the given values, e.g. "api/v1/users/${user.id}/#" with vars["user.id"].
+// Patterns without placeholders are returned unchanged. A pattern is dropped
+// if a placeholder has no value, or if the value is empty or contains a
+// separator or wildcard, so a crafted value can never widen a permission.
+func (m *Matcher) ExpandTemplates(patterns []string, vars map[string]string) []string {
+	expanded := make([]string, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "${") {
+			expanded = append(expanded, pattern)
+			continue
+		}
+
+		// Detect the schema without the placeholders, whose names contain dots
+		schemaType := m.DetectSchemaType(templateVariable.ReplaceAllString(pattern, "x"))
+
+		valid := true
+		result := templateVariable.ReplaceAllStringFunc(pattern, func(placeholder string) string {
+			value, found := vars[templateVariable.FindStringSubmatch(placeholder)[1]]
+			if !found || !isLiteralSegment(value, schemaType) {
+				valid = false
+			}
+			return value
+		})
+
+		// Unknown placeholder syntax is left over; treat it like a missing value
+		if valid && !strings.Contains(result, "${") {
+			expanded = append(expanded, result)
+		}
+	}
+
+	return expanded
+}
+
+// isLiteralSegment reports whether a value fits in exactly one topic segment
+func isLiteralSegment(value string, schemaType SchemaType) bool {
+	if value == "" {
+		return false
+	}
+
+	reserved := "/+#*>"
+	if schemaType == NATS {
+		reserved += "."
+	}
+	return !strings.ContainsAny(value, reserved)
+}